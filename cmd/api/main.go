@@ -3,35 +3,68 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"laile/internal/config"
 	"laile/internal/database"
 	"laile/internal/event"
+	"laile/internal/forwarders"
 	"laile/internal/log"
 	"laile/internal/server"
 )
 
 func main() {
 	log.InitLogger()
+	// Load .env before the config so ${...} placeholders can use it
+	_ = godotenv.Load()
 	appConfig, err := config.LoadMainConfig()
 	if err != nil {
 		panic(fmt.Sprintf("cannot load appConfig: %s", err))
 	}
-	_ = godotenv.Load()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTimeout := time.Duration(appConfig.Settings.ShutdownTimeout) * time.Second
 	db := database.New()
-	go event.ProcessEvents(context.Background(), db, appConfig)
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	serve := func(name string, httpServer func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpServer(); err != nil {
+				// A server that fails stops the others as well
+				log.Logger.Error("Server stopped", slog.Any("error", err), slog.String("server", name))
+				failed.Store(true)
+				stop()
+			}
+		}()
+	}
+	serve("admin", func() error {
+		return server.Run(ctx, server.NewAdminServer(db, appConfig), shutdownTimeout)
+	})
+	serve("ingress", func() error {
+		return server.Run(ctx, server.NewServer(db, appConfig), shutdownTimeout)
+	})
+	wg.Add(1)
 	go func() {
-		adminServer := server.NewAdminServer(db, appConfig)
-		err = adminServer.ListenAndServe()
-		if err != nil {
-			panic(fmt.Sprintf("cannot start admin server: %s", err))
-		}
+		defer wg.Done()
+		event.ProcessEvents(ctx, db, appConfig)
 	}()
 
-	ingressServer := server.NewServer(db, appConfig)
-	err = ingressServer.ListenAndServe()
-	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
+	<-ctx.Done()
+	log.Logger.Info("Shutting down")
+	wg.Wait()
+	forwarders.CloseConnections()
+	db.Close()
+	if failed.Load() {
+		os.Exit(1)
 	}
 }
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"laile/internal/config"
@@ -12,26 +20,43 @@ import (
 
 func main() {
 	log.InitLogger()
+	// Load .env before the config so ${...} placeholders can use it
+	_ = godotenv.Load()
 	conf, err := config.LoadMainConfig()
 	if err != nil {
 		panic(fmt.Sprintf("cannot load config: %s", err))
 	}
-	_ = godotenv.Load()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTimeout := time.Duration(conf.Settings.ShutdownTimeout) * time.Second
 	db := database.New()
 
-	// Start AdminServer in a goroutine
-	go func() {
-		adminServer := server.NewAdminServer(db, conf)
-		adminServerErr := adminServer.ListenAndServe()
-		if adminServerErr != nil {
-			panic(fmt.Sprintf("cannot start admin server: %s", adminServerErr))
-		}
-	}()
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	serve := func(name string, httpServer func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpServer(); err != nil {
+				// A server that fails stops the other one as well
+				log.Logger.Error("Server stopped", slog.Any("error", err), slog.String("server", name))
+				failed.Store(true)
+				stop()
+			}
+		}()
+	}
+	serve("admin", func() error {
+		return server.Run(ctx, server.NewAdminServer(db, conf), shutdownTimeout)
+	})
+	serve("ingress", func() error {
+		return server.Run(ctx, server.NewServer(db, conf), shutdownTimeout)
+	})
 
-	// Start ingress server (this blocks)
-	ingressServer := server.NewServer(db, conf)
-	err = ingressServer.ListenAndServe()
-	if err != nil {
-		panic(fmt.Sprintf("cannot start ingress server: %s", err))
+	<-ctx.Done()
+	log.Logger.Info("Shutting down")
+	wg.Wait()
+	db.Close()
+	if failed.Load() {
+		os.Exit(1)
 	}
 }
@@ -3,26 +3,33 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 	"laile/internal/config"
 	"laile/internal/database"
 	"laile/internal/event"
+	"laile/internal/forwarders"
 	"laile/internal/log"
 )
 
 func main() {
 	log.InitLogger()
+	// Load .env before the config so ${...} placeholders can use it
+	_ = godotenv.Load()
 	conf, err := config.LoadMainConfig()
 	if err != nil {
 		panic(fmt.Sprintf("cannot load config: %s", err))
 	}
-	_ = godotenv.Load()
-	db := database.New()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	db := database.NewWithMaxConns(event.MaxConns(conf.Settings))
 
-	// Run the event processor. Assuming this call blocks while processing events.
-	event.ProcessEvents(context.Background(), db, conf)
+	// Blocks until a signal arrives and the running deliveries are drained
+	event.ProcessEvents(ctx, db, conf)
 
-	// If ProcessEvents were to return immediately, add a blocking select:
-	// select {}
+	forwarders.CloseConnections()
+	db.Close()
 }
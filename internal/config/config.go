@@ -2,10 +2,18 @@ package config
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"path"
 	"regexp"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/go-playground/validator/v10"
+	"laile/internal/jsonpath"
 )
 
 type Config struct {
@@ -14,30 +22,204 @@ type Config struct {
 }
 
 type Settings struct {
-	TickerEnabled  bool `toml:"ticker_enabled"`
-	TickerInterval int  `toml:"ticker_interval" validate:"required_if=TickerEnabled true,gte=1"`
-	ListenerPort   int  `toml:"listener_port"   validate:"required,gte=1,lte=65535"`
-	AdminPort      int  `toml:"admin_port"      validate:"required,gte=1,lte=65535"`
+	TickerEnabled        bool   `toml:"ticker_enabled"`
+	TickerInterval       int    `toml:"ticker_interval"        validate:"required_if=TickerEnabled true,gte=1"`
+	ListenerPort         int    `toml:"listener_port"          validate:"required,gte=1,lte=65535"`
+	AdminPort            int    `toml:"admin_port"             validate:"required,gte=1,lte=65535"`
+	MaxBodyBytes         int64  `toml:"max_body_bytes"         validate:"gte=1"`                     // Upper limit for every service
+	StreamThresholdBytes int64  `toml:"stream_threshold_bytes" validate:"gte=1"`                     // Larger bodies are spooled to disk and stored as large objects
+	WorkerConcurrency    int    `toml:"worker_concurrency"     validate:"gte=1"`                     // Deliveries that run at the same time
+	NodeName             string `toml:"node_name"`                                                   // Name of this worker in the hash ring, defaults to the hostname with a random suffix
+	VirtualNodes         int    `toml:"virtual_nodes"          validate:"gte=1"`                     // Points this worker takes on the hash ring
+	HeartbeatInterval    int    `toml:"heartbeat_interval"     validate:"gte=1"`                     // Seconds between hash ring heartbeats
+	NodeTimeout          int    `toml:"node_timeout"           validate:"gtfield=HeartbeatInterval"` // Seconds without a heartbeat before a worker is removed from the ring
+	RetentionDays        int    `toml:"retention_days"         validate:"gte=0"`                     // Days delivered events are kept, 0 keeps them forever
+	ShutdownTimeout      int    `toml:"shutdown_timeout"       validate:"gte=1"`                     // Seconds running requests and deliveries get to finish on shutdown
 }
 
 type WebhookService struct {
-	Name                 string               // Populated from map key
-	Path                 string               `toml:"path"                  validate:"omitempty,alphanum"`
-	AuthenticationType   string               `toml:"authentication_type"   validate:"omitempty,oneof=header"`
-	AuthenticationHeader string               `toml:"authentication_header"`
-	AuthenticationSecret string               `toml:"authentication_secret"`
-	Forwarders           map[string]Forwarder `toml:"forwarders"            validate:"dive"`
+	Name                  string               // Populated from map key
+	Path                  string               `toml:"path"                   validate:"omitempty,alphanum"`
+	AuthenticationType    string               `toml:"authentication_type"    validate:"omitempty,authentication_type"`
+	AuthenticationHeader  string               `toml:"authentication_header"  validate:"required_unless=AuthenticationType standard_webhooks AuthenticationType ''"`
+	AuthenticationSecret  string               `toml:"authentication_secret"` // Shorthand for a single entry in AuthenticationSecrets
+	AuthenticationSecrets []Secret             `toml:"authentication_secrets" validate:"required_with=AuthenticationType,dive"`
+	SignatureEncoding     string               `toml:"signature_encoding"     validate:"omitempty,oneof=hex base64"` // How the signature is encoded in the header
+	SignaturePrefix       string               `toml:"signature_prefix"`                                             // Stripped from the header value, e.g. "sha256="
+	SignatureTolerance    int                  `toml:"signature_tolerance"    validate:"gt=0"`                       // Seconds a timestamped signature stays valid
+	Challenge             string               `toml:"challenge"              validate:"omitempty,oneof=slack meta microsoft_graph dropbox"`
+	ChallengeToken        string               `toml:"challenge_token"        validate:"required_if=Challenge meta"` // Meta's hub.verify_token
+	MaxBodyBytes          int64                `toml:"max_body_bytes"         validate:"gte=0"`                      // Defaults to the global limit
+	Dedup                 Dedup                `toml:"dedup"`
+	EventType             KeySource            `toml:"event_type"`
+	Response              Response             `toml:"response"`
+	Forwarders            map[string]Forwarder `toml:"forwarders"             validate:"dive"`
+	DeadLetter            *Forwarder           `toml:"dead_letter"` // Receives targets that ran out of retries
+}
+
+// authenticationTypes are the authentication types a service can use. The verifiers package
+// registers a type for every verifier, since this package can't import it.
+var authenticationTypes = struct {
+	sync.RWMutex
+	names map[string]struct{}
+}{names: make(map[string]struct{})}
+
+// RegisterAuthenticationType makes an authentication type pass the config validation.
+func RegisterAuthenticationType(name string) {
+	authenticationTypes.Lock()
+	defer authenticationTypes.Unlock()
+	authenticationTypes.names[name] = struct{}{}
+}
+
+// DefaultDropHeaders are removed from forwarded requests unless a forwarder sets drop_headers.
+// They describe the provider's connection to us, or carry credentials meant for us.
+var DefaultDropHeaders = []string{"Authorization", "Cookie", "Forwarded", "X-Forwarded-*", "X-Real-Ip"}
+
+// Secret is one of the signing secrets accepted by a webhook service. Several secrets can be active
+// while a provider rotates them.
+type Secret struct {
+	Name     string    `toml:"name"`
+	Value    string    `toml:"value"     validate:"required"`
+	NotAfter time.Time `toml:"not_after"` // The secret is no longer accepted after this time, zero means never
+}
+
+// Active reports whether the secret can still be used to verify requests at the given time.
+func (s Secret) Active(now time.Time) bool {
+	return s.NotAfter.IsZero() || now.Before(s.NotAfter)
+}
+
+// KeySource describes where a key is taken from in an inbound request.
+type KeySource struct {
+	Source   string `toml:"source"    validate:"omitempty,oneof=header json_path body_hash"`
+	Header   string `toml:"header"    validate:"required_if=Source header"`    // e.g. X-GitHub-Delivery
+	JSONPath string `toml:"json_path" validate:"required_if=Source json_path"` // e.g. $.id
+}
+
+// Dedup configures how provider retries of an event are recognised.
+type Dedup struct {
+	KeySource
+	Window int `toml:"window" validate:"gt=0"` // Seconds a key is remembered for
+}
+
+// Filter decides which events are sent to a forwarder. Every configured rule has to match,
+// and patterns use path.Match syntax, e.g. "invoice.*".
+type Filter struct {
+	EventTypes []string          `toml:"event_types"` // The event type has to match one of the patterns
+	Headers    map[string]string `toml:"headers"`     // Each header has to match its pattern
+	JSON       []JSONPredicate   `toml:"json"         validate:"dive"`
+}
+
+// Enabled reports whether the filter has any rules.
+func (f *Filter) Enabled() bool {
+	return len(f.EventTypes) > 0 || len(f.Headers) > 0 || len(f.JSON) > 0
+}
+
+// JSONPredicate matches a value in a JSON body.
+type JSONPredicate struct {
+	Path   string   `toml:"path"   validate:"required"`
+	Equals []string `toml:"equals"` // The value has to match one of the patterns, empty only requires the path to exist
+}
+
+// Transform rewrites the request sent to a forwarder. The body is either rendered from a
+// text/template or selected from the JSON body with a path, header values are templates.
+type Transform struct {
+	Body        *string           `toml:"body"`
+	Select      string            `toml:"select"       validate:"excluded_with=Body"` // e.g. $.data.object
+	ContentType string            `toml:"content_type"`                               // Defaults to application/json for select
+	Headers     map[string]string `toml:"headers"`
+
+	// These are populated during instantiation.
+	BodyTemplate    *template.Template            `toml:"-"`
+	HeaderTemplates map[string]*template.Template `toml:"-"`
+	SelectPath      *jsonpath.Path                `toml:"-"`
+}
+
+// Enabled reports whether the transform changes anything.
+func (t *Transform) Enabled() bool {
+	return t.BodyTemplate != nil || t.SelectPath != nil || len(t.HeaderTemplates) > 0
+}
+
+// Timeouts bound the parts of a delivery, in seconds.
+type Timeouts struct {
+	Total          int `toml:"total"           validate:"gt=0,lt=600"`          // The whole delivery, under the 10 minutes after which an attempt is taken as abandoned
+	Connect        int `toml:"connect"         validate:"gt=0,ltefield=Total"`  // Opening a connection
+	TLSHandshake   int `toml:"tls_handshake"   validate:"gt=0,ltefield=Total"`  // HTTP only
+	ResponseHeader int `toml:"response_header" validate:"gte=0,ltefield=Total"` // HTTP only, waiting for the response headers once the request is sent, 0 only applies total
+}
+
+// CircuitBreaker stops deliveries to a forwarder that keeps failing. The breaker opens after
+// too many consecutive failures or a too high failure rate, holds the forwarder's attempts while
+// it is open, and then lets probe deliveries through to decide whether to close again.
+type CircuitBreaker struct {
+	FailureThreshold int     `toml:"failure_threshold" validate:"gte=0"`       // Consecutive failures that open the breaker, 0 turns the rule off
+	FailureRate      float64 `toml:"failure_rate"      validate:"gte=0,lte=1"` // Share of failed deliveries in the window that opens the breaker, 0 turns the rule off
+	MinRequests      int     `toml:"min_requests"      validate:"gte=0"`       // Deliveries in the window before failure_rate applies
+	Window           int     `toml:"window"            validate:"gte=0"`       // Seconds the failure rate is measured over
+	OpenDuration     int     `toml:"open_duration"     validate:"gte=0"`       // Seconds the breaker stays open before probing
+	Probes           int     `toml:"probes"            validate:"gte=0"`       // Deliveries let through at the same time while half-open
+}
+
+// Enabled reports whether the breaker has a rule to open on.
+func (c *CircuitBreaker) Enabled() bool {
+	return c.FailureThreshold > 0 || c.FailureRate > 0
+}
+
+// RateLimit caps how fast deliveries go out to a forwarder. It is a token bucket that refills at
+// requests_per_second and holds up to burst tokens.
+type RateLimit struct {
+	RequestsPerSecond float64 `toml:"requests_per_second" validate:"gte=0"` // 0 turns the limit off
+	Burst             int     `toml:"burst"               validate:"gte=0"` // Deliveries that can go out at once after a quiet period
+}
+
+// Enabled reports whether the forwarder is rate limited.
+func (r *RateLimit) Enabled() bool {
+	return r.RequestsPerSecond > 0
+}
+
+// Response configures the synchronous reply sent to the provider once an event is stored.
+type Response struct {
+	StatusCode    int               `toml:"status_code"     validate:"gte=200,lte=299"`
+	Headers       map[string]string `toml:"headers"`
+	ContentType   string            `toml:"content_type"`
+	Body          *string           `toml:"body"`            // A text/template, nil keeps the default JSON body
+	EventIDHeader string            `toml:"event_id_header"` // Header carrying the stored event ID
+
+	// BodyTemplate is populated during instantiation from Body.
+	BodyTemplate *template.Template `toml:"-"`
 }
 
 type Forwarder struct {
 	Name string // Populated from map key
 	// Hash is populated during instantiation. This will be used to cache any forwarder connections in memory.
-	Hash       string
-	Type       string            `toml:"type"        validate:"required,oneof=http amqp"`
-	URL        string            `toml:"url"         validate:"required_if=Type http,omitempty,url"`
-	Headers    map[string]string `toml:"headers"`
-	RetryCount int               `toml:"retry_count" validate:"gte=0"`
-	RetryDelay string            `toml:"retry_delay" validate:"oneof=exponential fixed"`
+	Hash           string
+	Type           string            `toml:"type"             validate:"required,oneof=http amqp"`
+	URL            string            `toml:"url"              validate:"required_if=Type http,omitempty,url"`
+	Headers        map[string]string `toml:"headers"`
+	ForwardHeaders []string          `toml:"forward_headers"`                                            // Inbound headers passed on, empty passes all of them
+	DropHeaders    []string          `toml:"drop_headers"`                                               // Removed on top of hop-by-hop and signature headers
+	RetryCount     int               `toml:"retry_count"      validate:"gte=0"`                          // Retries after the first attempt before the target fails
+	RetryDelay     string            `toml:"retry_delay"      validate:"oneof=exponential linear fixed"` // How the delay grows with each retry
+	RetryBaseDelay int               `toml:"retry_base_delay" validate:"gt=0"`                           // Seconds before the first retry
+	RetryMaxDelay  int               `toml:"retry_max_delay"  validate:"gtefield=RetryBaseDelay"`        // Longest delay in seconds
+	RetryJitter    string            `toml:"retry_jitter"     validate:"oneof=full none"`                // "full" waits a random time up to the computed delay
+	SuccessCodes   []string          `toml:"success_status_codes"`                                       // e.g. ["2xx", "304"]
+	RetryableCodes []string          `toml:"retryable_status_codes"`                                     // Unsuccessful responses that are retried, others are permanent failures
+	MaxInFlight    int               `toml:"max_in_flight"    validate:"gte=0"`                          // Deliveries to this forwarder at the same time, 0 only applies worker_concurrency
+	OrderingKey    KeySource         `toml:"ordering_key"`                                               // Events with the same key are delivered one after the other, in the order received
+	CircuitBreaker CircuitBreaker    `toml:"circuit_breaker"`
+	RateLimit      RateLimit         `toml:"rate_limit"`
+	Timeouts       Timeouts          `toml:"timeouts"`
+	MaxIdleConns   int               `toml:"max_idle_conns"   validate:"gte=0"` // HTTP only, idle connections kept open for reuse
+	IdleTimeout    int               `toml:"idle_timeout"     validate:"gte=0"` // HTTP only, seconds an idle connection is kept open
+	Filter         Filter            `toml:"filter"`
+	Transform      Transform         `toml:"transform"`
+
+	// IdempotencyKeyHeader carries the target's idempotency key on HTTP deliveries.
+	IdempotencyKeyHeader string `toml:"idempotency_key_header"`
+
+	// SuccessStatusRanges and RetryableStatusRanges are populated during instantiation.
+	SuccessStatusRanges   []StatusCodeRange `toml:"-"`
+	RetryableStatusRanges []StatusCodeRange `toml:"-"`
 
 	// AMQP specific fields
 	ConnectionURL string `toml:"connection_url" validate:"required_if=Type amqp,omitempty,url"`
@@ -61,25 +243,121 @@ const (
 
 	// DefaultTickerInterval is the default interval for the event ticker.
 	DefaultTickerInterval = 5
+
+	// DefaultSecretName is the name given to the secret set with authentication_secret.
+	DefaultSecretName = "default"
+
+	// DefaultEventIDHeader is the response header that returns the stored event ID to the sender.
+	DefaultEventIDHeader = "Laile-Event-Id"
+
+	// DefaultIdempotencyKeyHeader is the header that sends a target's idempotency key to HTTP forwarders.
+	DefaultIdempotencyKeyHeader = "Laile-Idempotency-Key"
+
+	// DefaultResponseBody is the body sent after an event is stored when no template is configured.
+	DefaultResponseBody = `{"status":"ok"}`
+
+	// DefaultMaxBodyBytes is the largest request body accepted by the listener.
+	DefaultMaxBodyBytes = 10 << 20
+
+	// DefaultStreamThresholdBytes is the body size above which payloads are no longer buffered in memory.
+	DefaultStreamThresholdBytes = 1 << 20
+
+	// DefaultWorkerConcurrency is how many deliveries run at the same time.
+	DefaultWorkerConcurrency = 10
+
+	// DefaultVirtualNodes is how many points a worker takes on the hash ring. More points spread the
+	// work more evenly between workers.
+	DefaultVirtualNodes = 16
+
+	// DefaultHeartbeatInterval is how many seconds pass between two heartbeats of a worker.
+	DefaultHeartbeatInterval = 10
+
+	// DefaultNodeTimeout is how many seconds a worker stays in the hash ring without a heartbeat.
+	DefaultNodeTimeout = 30
+
+	// DefaultShutdownTimeout is how many seconds running requests and deliveries get to finish on
+	// shutdown, which fits in the usual 30 second grace period of orchestrators.
+	DefaultShutdownTimeout = 25
+
+	// DefaultDedupWindow is how many seconds a deduplication key is remembered for.
+	DefaultDedupWindow = 24 * 60 * 60
+
+	// DefaultSignatureTolerance is how many seconds a timestamped signature is accepted for, matching Stripe's SDKs.
+	DefaultSignatureTolerance = 300
+
+	// DeadLetterForwarderName is the forwarder ID of a service's dead-letter forwarder.
+	DeadLetterForwarderName = "dead_letter"
+
+	// DefaultRetryCount is how many times a failed delivery is retried before the target fails.
+	DefaultRetryCount = 3
+
+	// DefaultRetryBaseDelay is the number of seconds the retry delays are computed from.
+	DefaultRetryBaseDelay = 2
+
+	// DefaultRetryMaxDelay caps the delay between two delivery attempts, in seconds.
+	DefaultRetryMaxDelay = 60 * 60
+
+	// DefaultCircuitBreakerMinRequests is how many deliveries a failure rate needs to be meaningful.
+	DefaultCircuitBreakerMinRequests = 20
+
+	// DefaultCircuitBreakerWindow is how many seconds a failure rate is measured over.
+	DefaultCircuitBreakerWindow = 60
+
+	// DefaultCircuitBreakerOpenDuration is how many seconds an open breaker waits before probing.
+	DefaultCircuitBreakerOpenDuration = 30
+
+	// DefaultCircuitBreakerProbes is how many probe deliveries a half-open breaker lets through.
+	DefaultCircuitBreakerProbes = 1
+
+	// DefaultTimeout is how many seconds a delivery may take in total.
+	DefaultTimeout = 30
+
+	// DefaultConnectTimeout is how many seconds opening a connection to a forwarder may take.
+	DefaultConnectTimeout = 10
+
+	// DefaultTLSHandshakeTimeout is how many seconds a TLS handshake with a forwarder may take.
+	DefaultTLSHandshakeTimeout = 10
+
+	// DefaultMaxIdleConns is how many idle connections an HTTP forwarder keeps open for reuse.
+	DefaultMaxIdleConns = 10
+
+	// DefaultIdleTimeout is how many seconds an HTTP forwarder keeps an idle connection open.
+	DefaultIdleTimeout = 90
+
+	// DefaultRateLimitBurst is how many deliveries a rate limited forwarder sends at once.
+	DefaultRateLimitBurst = 1
 )
 
 func loadConfig(path string) (*Config, error) {
 	config := &Config{
 		Settings: Settings{
 			// Default settings that work well for most deployments.
-			ListenerPort:   DefaultListenerPort,
-			AdminPort:      DefaultAdminPort,
-			TickerEnabled:  true,
-			TickerInterval: DefaultTickerInterval,
+			ListenerPort:         DefaultListenerPort,
+			AdminPort:            DefaultAdminPort,
+			TickerEnabled:        true,
+			TickerInterval:       DefaultTickerInterval,
+			MaxBodyBytes:         DefaultMaxBodyBytes,
+			StreamThresholdBytes: DefaultStreamThresholdBytes,
+			WorkerConcurrency:    DefaultWorkerConcurrency,
+			VirtualNodes:         DefaultVirtualNodes,
+			HeartbeatInterval:    DefaultHeartbeatInterval,
+			NodeTimeout:          DefaultNodeTimeout,
+			ShutdownTimeout:      DefaultShutdownTimeout,
 		},
 		WebhookServices: make(map[string]WebhookService),
 	}
 
 	// Read TOML file
-	if _, err := toml.DecodeFile(path, config); err != nil {
+	meta, err := toml.DecodeFile(path, config)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode application config toml: %w", err)
 	}
 
+	// Resolve ${...} placeholders before defaults and validation are applied
+	if err := interpolateConfig(config); err != nil {
+		return nil, fmt.Errorf("failed to interpolate application config: %w", err)
+	}
+
 	// Create validator
 	validate := validator.New()
 
@@ -87,39 +365,106 @@ func loadConfig(path string) (*Config, error) {
 	if err := validate.RegisterValidation("alphanum", validateAlphanumeric); err != nil {
 		return nil, fmt.Errorf("failed to register alphanum validation: %w", err)
 	}
+	if err := validate.RegisterValidation("authentication_type", validateAuthenticationType); err != nil {
+		return nil, fmt.Errorf("failed to register authentication_type validation: %w", err)
+	}
 
 	// Populate Name fields from map keys and set defaults for each service
 	for serviceName, service := range config.WebhookServices {
 		service.Name = serviceName
+		if service.SignatureEncoding == "" {
+			service.SignatureEncoding = "hex" // Most providers send hex encoded digests
+		}
+		if service.SignatureTolerance == 0 {
+			// A tolerance of 0 would reject every signature, so only a missing setting gets the default
+			if meta.IsDefined("webhook_services", serviceName, "signature_tolerance") {
+				return nil, fmt.Errorf("signature_tolerance of service %s must be greater than 0", serviceName)
+			}
+			service.SignatureTolerance = DefaultSignatureTolerance
+		}
+		if service.MaxBodyBytes == 0 {
+			service.MaxBodyBytes = config.Settings.MaxBodyBytes
+		}
+		if service.MaxBodyBytes > config.Settings.MaxBodyBytes {
+			return nil, fmt.Errorf("max_body_bytes of service %s exceeds the global limit of %d bytes",
+				serviceName, config.Settings.MaxBodyBytes)
+		}
+		if service.AuthenticationType == "stripe" && service.AuthenticationHeader == "" {
+			service.AuthenticationHeader = "Stripe-Signature"
+		}
 
-		// Set default forwarder values if not specified
-		for forwarderName, forwarder := range service.Forwarders {
-			forwarder.Name = forwarderName
-			forwarder.Hash = generateUniqueName(serviceName, forwarderName)
-
-			// Set sensible defaults for forwarder
-			if forwarder.RetryCount == 0 {
-				forwarder.RetryCount = 3 // Default to 3 retries
+		// The single secret shorthand is treated as the first secret in the list
+		if service.AuthenticationSecret != "" {
+			service.AuthenticationSecrets = append([]Secret{{
+				Name:     DefaultSecretName,
+				Value:    service.AuthenticationSecret,
+				NotAfter: time.Time{},
+			}}, service.AuthenticationSecrets...)
+		}
+		for i := range service.AuthenticationSecrets {
+			if service.AuthenticationSecrets[i].Name == "" {
+				service.AuthenticationSecrets[i].Name = fmt.Sprintf("secret_%d", i+1)
 			}
-			if forwarder.RetryDelay == "" {
-				forwarder.RetryDelay = "exponential" // Default to exponential backoff
+		}
+		// Accepted events record the secret by name, so every name has to point to one secret
+		secretNames := make(map[string]struct{}, len(service.AuthenticationSecrets))
+		for _, secret := range service.AuthenticationSecrets {
+			if _, exists := secretNames[secret.Name]; exists {
+				return nil, fmt.Errorf("secret name %s is used more than once in service %s", secret.Name, serviceName)
 			}
+			secretNames[secret.Name] = struct{}{}
+		}
 
-			// AMQP defaults for reliability
-			if forwarder.Type == "amqp" {
-				if !forwarder.Durable {
-					forwarder.Durable = true // Default to durable queues
-				}
-				if !forwarder.Persistent {
-					forwarder.Persistent = true // Default to persistent messages
-				}
-				if forwarder.ExchangeType == "" {
-					forwarder.ExchangeType = "direct" // Default exchange type
-				}
+		if service.Dedup.Window == 0 {
+			// A window of 0 would never catch a duplicate, so only a missing setting gets the default
+			if meta.IsDefined("webhook_services", serviceName, "dedup", "window") {
+				return nil, fmt.Errorf("dedup window of service %s must be greater than 0", serviceName)
 			}
+			service.Dedup.Window = DefaultDedupWindow
+		}
+		if err := validateKeySource(serviceName, service.Dedup.KeySource); err != nil {
+			return nil, err
+		}
+		if service.EventType.Source == "body_hash" {
+			return nil, fmt.Errorf("event_type of service %s can't use source body_hash", serviceName)
+		}
+		if err := validateKeySource(serviceName, service.EventType); err != nil {
+			return nil, err
+		}
 
+		if err := setResponseDefaults(serviceName, &service.Response); err != nil {
+			return nil, err
+		}
+
+		// Set default forwarder values if not specified
+		for forwarderName, forwarder := range service.Forwarders {
+			isDefined := func(key string) bool {
+				return meta.IsDefined("webhook_services", serviceName, "forwarders", forwarderName, key)
+			}
+			if err := setForwarderDefaults(&service, forwarderName, &forwarder, isDefined); err != nil {
+				return nil, err
+			}
 			service.Forwarders[forwarderName] = forwarder
 		}
+		if service.DeadLetter != nil {
+			if _, exists := service.Forwarders[DeadLetterForwarderName]; exists {
+				return nil, fmt.Errorf("forwarder name %s of service %s is reserved for the dead-letter forwarder",
+					DeadLetterForwarderName, serviceName)
+			}
+			isDefined := func(key string) bool {
+				return meta.IsDefined("webhook_services", serviceName, "dead_letter", key)
+			}
+			if err := setForwarderDefaults(&service, DeadLetterForwarderName, service.DeadLetter, isDefined); err != nil {
+				return nil, err
+			}
+			// The dead-letter message is built by laile, so there is nothing to filter, rewrite or order
+			if service.DeadLetter.Filter.Enabled() || service.DeadLetter.Transform.Enabled() ||
+				service.DeadLetter.OrderingKey.Source != "" || service.DeadLetter.CircuitBreaker.Enabled() ||
+				service.DeadLetter.RateLimit.Enabled() {
+				return nil, fmt.Errorf("dead-letter forwarder of service %s can't have a filter, transform, ordering_key, "+
+					"circuit_breaker or rate_limit", serviceName)
+			}
+		}
 		config.WebhookServices[serviceName] = service
 	}
 
@@ -131,6 +476,241 @@ func loadConfig(path string) (*Config, error) {
 	return config, nil
 }
 
+// setForwarderDefaults populates the derived fields of a forwarder and fills in its defaults.
+// isDefined reports whether a key is set in the forwarder's table, for settings where 0 is a valid value.
+func setForwarderDefaults(service *WebhookService, forwarderName string, forwarder *Forwarder, isDefined func(key string) bool) error {
+	forwarder.Name = forwarderName
+	forwarder.Hash = generateUniqueName(service.Name, forwarderName)
+	if forwarder.DropHeaders == nil {
+		forwarder.DropHeaders = slices.Clone(DefaultDropHeaders)
+	}
+	// Internal services never see the provider's signature
+	forwarder.DropHeaders = append(forwarder.DropHeaders, signatureHeaders(*service)...)
+	if err := validateHeaderPatterns(forwarder.ForwardHeaders, forwarder.DropHeaders); err != nil {
+		return fmt.Errorf("invalid header list for forwarder %s of service %s: %w", forwarderName, service.Name, err)
+	}
+
+	if err := validateFilter(forwarder.Filter); err != nil {
+		return fmt.Errorf("invalid filter for forwarder %s of service %s: %w", forwarderName, service.Name, err)
+	}
+	if err := parseTransform(forwarder.Hash, &forwarder.Transform); err != nil {
+		return fmt.Errorf("invalid transform for forwarder %s of service %s: %w", forwarderName, service.Name, err)
+	}
+	// Every body hashes differently, so the key would never match another event
+	if forwarder.OrderingKey.Source == "body_hash" {
+		return fmt.Errorf("ordering_key of forwarder %s of service %s can't use source body_hash", forwarderName, service.Name)
+	}
+	if err := validateKeySource(service.Name, forwarder.OrderingKey); err != nil {
+		return err
+	}
+
+	if forwarder.SuccessCodes == nil {
+		forwarder.SuccessCodes = slices.Clone(DefaultSuccessStatusCodes)
+	}
+	if forwarder.RetryableCodes == nil {
+		forwarder.RetryableCodes = slices.Clone(DefaultRetryableStatusCodes)
+	}
+	var err error
+	if forwarder.SuccessStatusRanges, err = parseStatusCodes(forwarder.SuccessCodes); err != nil {
+		return fmt.Errorf("invalid success_status_codes for forwarder %s of service %s: %w", forwarderName, service.Name, err)
+	}
+	if forwarder.RetryableStatusRanges, err = parseStatusCodes(forwarder.RetryableCodes); err != nil {
+		return fmt.Errorf("invalid retryable_status_codes for forwarder %s of service %s: %w", forwarderName, service.Name, err)
+	}
+
+	// Set sensible defaults for forwarder
+	// retry_count = 0 delivers once and never retries, so only a missing setting gets the default
+	if forwarder.RetryCount == 0 && !isDefined("retry_count") {
+		forwarder.RetryCount = DefaultRetryCount
+	}
+	if forwarder.RetryDelay == "" {
+		forwarder.RetryDelay = "exponential" // Default to exponential backoff
+	}
+	if forwarder.RetryBaseDelay == 0 {
+		forwarder.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+	if forwarder.RetryMaxDelay == 0 {
+		forwarder.RetryMaxDelay = max(DefaultRetryMaxDelay, forwarder.RetryBaseDelay)
+	}
+	if forwarder.RetryJitter == "" {
+		forwarder.RetryJitter = "full" // Spread retries of events that failed together
+	}
+	if forwarder.CircuitBreaker.Enabled() {
+		setCircuitBreakerDefaults(&forwarder.CircuitBreaker)
+	}
+	if forwarder.RateLimit.Enabled() && forwarder.RateLimit.Burst == 0 {
+		forwarder.RateLimit.Burst = DefaultRateLimitBurst
+	}
+	setTimeoutDefaults(&forwarder.Timeouts)
+	if forwarder.MaxIdleConns == 0 {
+		forwarder.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if forwarder.IdleTimeout == 0 {
+		forwarder.IdleTimeout = DefaultIdleTimeout
+	}
+	if forwarder.IdempotencyKeyHeader == "" {
+		forwarder.IdempotencyKeyHeader = DefaultIdempotencyKeyHeader
+	}
+
+	// AMQP defaults for reliability
+	if forwarder.Type == "amqp" {
+		if !forwarder.Durable {
+			forwarder.Durable = true // Default to durable queues
+		}
+		if !forwarder.Persistent {
+			forwarder.Persistent = true // Default to persistent messages
+		}
+		if forwarder.ExchangeType == "" {
+			forwarder.ExchangeType = "direct" // Default exchange type
+		}
+	}
+
+	return nil
+}
+
+// setTimeoutDefaults fills in the timeouts that aren't set, without going over the total.
+func setTimeoutDefaults(timeouts *Timeouts) {
+	if timeouts.Total == 0 {
+		timeouts.Total = DefaultTimeout
+	}
+	if timeouts.Connect == 0 {
+		timeouts.Connect = min(DefaultConnectTimeout, timeouts.Total)
+	}
+	if timeouts.TLSHandshake == 0 {
+		timeouts.TLSHandshake = min(DefaultTLSHandshakeTimeout, timeouts.Total)
+	}
+}
+
+func setCircuitBreakerDefaults(breaker *CircuitBreaker) {
+	if breaker.MinRequests == 0 {
+		breaker.MinRequests = DefaultCircuitBreakerMinRequests
+	}
+	if breaker.Window == 0 {
+		breaker.Window = DefaultCircuitBreakerWindow
+	}
+	if breaker.OpenDuration == 0 {
+		breaker.OpenDuration = DefaultCircuitBreakerOpenDuration
+	}
+	if breaker.Probes == 0 {
+		breaker.Probes = DefaultCircuitBreakerProbes
+	}
+}
+
+// validateKeySource checks the parts of a key source the validator can't, like JSON path syntax.
+func validateKeySource(serviceName string, source KeySource) error {
+	if source.Source != "json_path" {
+		return nil
+	}
+	if _, err := jsonpath.Parse(source.JSONPath); err != nil {
+		return fmt.Errorf("invalid json_path for service %s: %w", serviceName, err)
+	}
+	return nil
+}
+
+// signatureHeaders returns the headers that carry the service's request signature.
+func signatureHeaders(service WebhookService) []string {
+	switch service.AuthenticationType {
+	case "":
+		return nil
+	case "standard_webhooks":
+		return []string{"Webhook-Signature", "Svix-Signature"}
+	default:
+		return []string{service.AuthenticationHeader}
+	}
+}
+
+// validateHeaderPatterns checks the header allow and deny lists, which accept path.Match patterns.
+func validateHeaderPatterns(lists ...[]string) error {
+	for _, list := range lists {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateFilter checks the filter patterns and JSON paths so mistakes are reported at startup.
+func validateFilter(filter Filter) error {
+	patterns := slices.Clone(filter.EventTypes)
+	for _, pattern := range filter.Headers {
+		patterns = append(patterns, pattern)
+	}
+	for _, predicate := range filter.JSON {
+		if _, err := jsonpath.Parse(predicate.Path); err != nil {
+			return err
+		}
+		patterns = append(patterns, predicate.Equals...)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// parseTransform parses the transform templates and select path.
+func parseTransform(name string, transform *Transform) error {
+	if transform.Body != nil {
+		bodyTemplate, err := template.New(name).Option("missingkey=zero").Funcs(TemplateFuncs).Parse(*transform.Body)
+		if err != nil {
+			return err
+		}
+		transform.BodyTemplate = bodyTemplate
+	}
+
+	if transform.Select != "" {
+		selectPath, err := jsonpath.Parse(transform.Select)
+		if err != nil {
+			return err
+		}
+		transform.SelectPath = selectPath
+		if transform.ContentType == "" {
+			transform.ContentType = "application/json"
+		}
+	}
+
+	transform.HeaderTemplates = make(map[string]*template.Template, len(transform.Headers))
+	for header, value := range transform.Headers {
+		headerTemplate, err := template.New(name + "_" + header).Option("missingkey=zero").Funcs(TemplateFuncs).Parse(value)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", header, err)
+		}
+		transform.HeaderTemplates[header] = headerTemplate
+	}
+	return nil
+}
+
+// setResponseDefaults fills in the default ingress reply, and parses and checks the body template.
+func setResponseDefaults(serviceName string, response *Response) error {
+	if response.StatusCode == 0 {
+		response.StatusCode = http.StatusOK
+	}
+	if response.EventIDHeader == "" {
+		response.EventIDHeader = DefaultEventIDHeader
+	}
+	if response.Body == nil {
+		body := DefaultResponseBody
+		response.Body = &body
+		if response.ContentType == "" {
+			response.ContentType = "application/json"
+		}
+	}
+
+	bodyTemplate, err := template.New(serviceName).Option("missingkey=error").Funcs(TemplateFuncs).Parse(*response.Body)
+	if err != nil {
+		return fmt.Errorf("invalid response body template for service %s: %w", serviceName, err)
+	}
+	// Parsing doesn't catch unknown fields, rendering a sample does
+	if err = bodyTemplate.Execute(io.Discard, sampleResponseData(serviceName)); err != nil {
+		return fmt.Errorf("invalid response body template for service %s: %w", serviceName, err)
+	}
+	response.BodyTemplate = bodyTemplate
+	return nil
+}
+
 // validateAlphanumeric is the custom validator for alphanumeric values.
 func validateAlphanumeric(fl validator.FieldLevel) bool {
 	value := fl.Field().String()
@@ -141,6 +721,15 @@ func validateAlphanumeric(fl validator.FieldLevel) bool {
 	return matched
 }
 
+// validateAuthenticationType is the custom validator for authentication types, it accepts the
+// types that have a registered verifier.
+func validateAuthenticationType(fl validator.FieldLevel) bool {
+	authenticationTypes.RLock()
+	defer authenticationTypes.RUnlock()
+	_, ok := authenticationTypes.names[fl.Field().String()]
+	return ok
+}
+
 func LoadMainConfig() (*Config, error) {
 	config, err := loadConfig("webhook_config.toml")
 	if err != nil {
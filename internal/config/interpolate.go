@@ -0,0 +1,187 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Provider resolves the key of a ${provider:key} placeholder.
+// It returns false when the key doesn't exist, so a default value can be used instead.
+type Provider interface {
+	Lookup(key string) (string, bool, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface.
+type ProviderFunc func(key string) (string, bool, error)
+
+func (f ProviderFunc) Lookup(key string) (string, bool, error) {
+	return f(key)
+}
+
+type providerRegistry struct {
+	sync.RWMutex
+	providers map[string]Provider
+}
+
+var globalProviders = &providerRegistry{
+	RWMutex: sync.RWMutex{},
+	providers: map[string]Provider{
+		"env":  ProviderFunc(lookupEnv),
+		"file": ProviderFunc(lookupFile),
+	},
+}
+
+// RegisterProvider adds or replaces the provider used for ${name:key} placeholders.
+func RegisterProvider(name string, provider Provider) {
+	globalProviders.Lock()
+	defer globalProviders.Unlock()
+	globalProviders.providers[name] = provider
+}
+
+func getProvider(name string) (Provider, bool) {
+	globalProviders.RLock()
+	defer globalProviders.RUnlock()
+	provider, ok := globalProviders.providers[name]
+	return provider, ok
+}
+
+func lookupEnv(key string) (string, bool, error) {
+	value, ok := os.LookupEnv(key)
+	return value, ok, nil
+}
+
+// lookupFile reads secrets mounted as files, e.g. Docker or Kubernetes secrets.
+// A single trailing newline is removed since most tools add one.
+func lookupFile(path string) (string, bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	value := strings.TrimSuffix(string(content), "\n")
+	return strings.TrimSuffix(value, "\r"), true, nil
+}
+
+// placeholderPattern matches ${...} placeholders and $${...} escapes.
+var placeholderPattern = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+const defaultSeparator = ":-"
+
+// interpolate replaces the placeholders in a value. Supported forms are:
+//
+//	${NAME}              environment variable NAME
+//	${env:NAME}          environment variable NAME
+//	${file:/run/secret}  contents of the file
+//	${NAME:-fallback}    fallback when NAME is not set, works with any provider
+//	$${NAME}             the literal text ${NAME}
+func interpolate(value string) (string, []error) {
+	var errs []error
+	result := placeholderPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+
+		expression := match[2 : len(match)-1]
+		expression, fallback, hasFallback := strings.Cut(expression, defaultSeparator)
+
+		providerName, key := "env", expression
+		if name, rest, found := strings.Cut(expression, ":"); found {
+			providerName, key = name, rest
+		}
+
+		provider, ok := getProvider(providerName)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown provider %q in %s", providerName, match))
+			return match
+		}
+
+		resolved, found, err := provider.Lookup(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to resolve %s: %w", match, err))
+			return match
+		}
+		if !found {
+			if hasFallback {
+				return fallback
+			}
+			errs = append(errs, fmt.Errorf("%s is not set", match))
+			return match
+		}
+		return resolved
+	})
+	return result, errs
+}
+
+// interpolateConfig resolves placeholders in every string of the config, including map values and
+// slices. All unresolved placeholders are reported together with the path of the field they are in.
+func interpolateConfig(config *Config) error {
+	var errs []error
+	interpolateValue(reflect.ValueOf(config).Elem(), "", &errs)
+	return errors.Join(errs...)
+}
+
+func interpolateValue(value reflect.Value, path string, errs *[]error) {
+	switch value.Kind() {
+	case reflect.String:
+		interpolated, valueErrs := interpolate(value.String())
+		for _, err := range valueErrs {
+			*errs = append(*errs, fmt.Errorf("%s: %w", path, err))
+		}
+		if len(valueErrs) == 0 {
+			value.SetString(interpolated)
+		}
+	case reflect.Struct:
+		for i := range value.NumField() {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if field.Anonymous && name == "" {
+				interpolateValue(value.Field(i), path, errs) // Embedded structs are flattened like in TOML
+				continue
+			}
+			if name == "" || name == "-" {
+				continue // Fields without a toml name are populated in code, not read from the file
+			}
+			interpolateValue(value.Field(i), joinPath(path, name), errs)
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+		})
+		for _, key := range keys {
+			// Map values aren't addressable, so interpolate a copy and store it back
+			element := reflect.New(value.Type().Elem()).Elem()
+			element.Set(value.MapIndex(key))
+			interpolateValue(element, joinPath(path, fmt.Sprint(key.Interface())), errs)
+			value.SetMapIndex(key, element)
+		}
+	case reflect.Slice:
+		for i := range value.Len() {
+			interpolateValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Pointer:
+		if !value.IsNil() {
+			interpolateValue(value.Elem(), path, errs)
+		}
+	default:
+		// Numbers, booleans and times can't contain placeholders
+	}
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
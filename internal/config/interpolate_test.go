@@ -0,0 +1,137 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSecretFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing the secret file failed: %v", err)
+	}
+	return path
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("LAILE_TEST_TOKEN", "t0ken")
+	t.Setenv("LAILE_TEST_EMPTY", "")
+	secretFile := writeSecretFile(t, "from-file\n")
+	windowsFile := writeSecretFile(t, "from-file\r\n")
+	multilineFile := writeSecretFile(t, "line1\nline2\n\n")
+	RegisterProvider("test", ProviderFunc(func(key string) (string, bool, error) {
+		if key == "broken" {
+			return "", false, errors.New("provider failed")
+		}
+		return strings.ToUpper(key), key != "missing", nil
+	}))
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		valid bool
+	}{
+		{name: "no placeholder", value: "plain $text", want: "plain $text", valid: true},
+		{name: "bare name", value: "${LAILE_TEST_TOKEN}", want: "t0ken", valid: true},
+		{name: "env provider", value: "${env:LAILE_TEST_TOKEN}", want: "t0ken", valid: true},
+		{name: "inside text", value: "Bearer ${LAILE_TEST_TOKEN}!", want: "Bearer t0ken!", valid: true},
+		{name: "several placeholders", value: "${LAILE_TEST_TOKEN}:${env:LAILE_TEST_TOKEN}", want: "t0ken:t0ken", valid: true},
+		{name: "empty variable is set", value: "${LAILE_TEST_EMPTY:-fallback}", want: "", valid: true},
+		{name: "fallback", value: "${LAILE_TEST_MISSING:-fallback}", want: "fallback", valid: true},
+		{name: "empty fallback", value: "${LAILE_TEST_MISSING:-}", want: "", valid: true},
+		{name: "fallback with separators", value: "${LAILE_TEST_MISSING:-a:b:-c}", want: "a:b:-c", valid: true},
+		{name: "fallback is unused when set", value: "${env:LAILE_TEST_TOKEN:-fallback}", want: "t0ken", valid: true},
+		{name: "file", value: "${file:" + secretFile + "}", want: "from-file", valid: true},
+		{name: "file with a windows line ending", value: "${file:" + windowsFile + "}", want: "from-file", valid: true},
+		{name: "file keeps inner newlines", value: "${file:" + multilineFile + "}", want: "line1\nline2\n", valid: true},
+		{name: "missing file with fallback", value: "${file:/nonexistent/secret:-fallback}", want: "fallback", valid: true},
+		{name: "registered provider", value: "${test:key}", want: "KEY", valid: true},
+		{name: "escape", value: "$${LAILE_TEST_TOKEN}", want: "${LAILE_TEST_TOKEN}", valid: true},
+		{name: "escape next to a placeholder", value: "$${NAME}=${LAILE_TEST_TOKEN}", want: "${NAME}=t0ken", valid: true},
+		{name: "missing variable", value: "${LAILE_TEST_MISSING}"},
+		{name: "missing file", value: "${file:/nonexistent/secret}"},
+		{name: "unreadable file", value: "${file:" + filepath.Dir(secretFile) + "}"},
+		{name: "unknown provider", value: "${vault:secret/token}"},
+		{name: "provider error with fallback", value: "${test:broken:-fallback}"},
+		{name: "missing key of a registered provider", value: "${test:missing}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := interpolate(tt.value)
+			if !tt.valid {
+				if len(errs) == 0 {
+					t.Fatalf("interpolate(%q) = %q, want an error", tt.value, got)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("interpolate(%q) failed: %v", tt.value, errs)
+			}
+			if got != tt.want {
+				t.Errorf("interpolate(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateConfig(t *testing.T) {
+	t.Setenv("LAILE_TEST_TOKEN", "t0ken")
+	config := &Config{
+		Settings: Settings{NodeName: "${LAILE_TEST_NODE:-worker-1}"},
+		WebhookServices: map[string]WebhookService{
+			"github": {
+				Name:                  "${LAILE_TEST_TOKEN}",
+				AuthenticationSecret:  "${LAILE_TEST_TOKEN}",
+				AuthenticationSecrets: []Secret{{Name: "next", Value: "${LAILE_TEST_NEXT}"}},
+				Dedup:                 Dedup{KeySource: KeySource{Source: "header", Header: "${LAILE_TEST_DEDUP}"}},
+				Forwarders: map[string]Forwarder{
+					"jira": {Headers: map[string]string{"Authorization": "Bearer ${LAILE_TEST_TOKEN}"}},
+				},
+				DeadLetter: &Forwarder{URL: "https://dead.example.com/${env:LAILE_TEST_TOKEN}"},
+			},
+		},
+	}
+
+	err := interpolateConfig(config)
+	if err == nil {
+		t.Fatal("interpolateConfig() succeeded, want the unset placeholders reported")
+	}
+	// Every unresolved placeholder is reported with the path of its field
+	for _, want := range []string{
+		"webhook_services.github.authentication_secrets[0].value: ${LAILE_TEST_NEXT} is not set",
+		"webhook_services.github.dedup.header: ${LAILE_TEST_DEDUP} is not set",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("interpolateConfig() error = %q, want it to contain %q", err, want)
+		}
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 2 {
+		t.Errorf("interpolateConfig() error = %q, want two joined errors", err)
+	}
+
+	service := config.WebhookServices["github"]
+	if config.Settings.NodeName != "worker-1" {
+		t.Errorf("node_name = %q, want the fallback", config.Settings.NodeName)
+	}
+	if service.AuthenticationSecret != "t0ken" {
+		t.Errorf("authentication_secret = %q, want %q", service.AuthenticationSecret, "t0ken")
+	}
+	if got := service.Forwarders["jira"].Headers["Authorization"]; got != "Bearer t0ken" {
+		t.Errorf("map value = %q, want %q", got, "Bearer t0ken")
+	}
+	if service.DeadLetter.URL != "https://dead.example.com/t0ken" {
+		t.Errorf("dead_letter url = %q, want the placeholder resolved", service.DeadLetter.URL)
+	}
+	if service.Name != "${LAILE_TEST_TOKEN}" {
+		t.Errorf("Name = %q, fields without a toml name should be left alone", service.Name)
+	}
+	// Strings with an unresolved placeholder are kept as they were
+	if service.AuthenticationSecrets[0].Value != "${LAILE_TEST_NEXT}" {
+		t.Errorf("unresolved value = %q, want it unchanged", service.AuthenticationSecrets[0].Value)
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultSuccessStatusCodes are the responses that complete a delivery.
+var DefaultSuccessStatusCodes = []string{"2xx"}
+
+// DefaultRetryableStatusCodes are the unsuccessful responses that are worth retrying. They describe
+// a busy or broken receiver, while other client errors won't go away by sending the event again.
+var DefaultRetryableStatusCodes = []string{"408", "425", "429", "5xx"}
+
+// StatusCodeRange is an inclusive range of HTTP status codes.
+type StatusCodeRange struct {
+	Min int
+	Max int
+}
+
+// parseStatusCodes parses status codes written as a single code ("404"), a class ("5xx") or a
+// range ("500-504").
+func parseStatusCodes(codes []string) ([]StatusCodeRange, error) {
+	ranges := make([]StatusCodeRange, 0, len(codes))
+	for _, code := range codes {
+		code = strings.ToLower(strings.TrimSpace(code))
+
+		var codeRange StatusCodeRange
+		var err error
+		switch {
+		case len(code) == 3 && strings.HasSuffix(code, "xx"):
+			var class int
+			class, err = strconv.Atoi(code[:1])
+			codeRange = StatusCodeRange{Min: class * 100, Max: class*100 + 99}
+		case strings.Contains(code, "-"):
+			low, high, _ := strings.Cut(code, "-")
+			codeRange.Min, err = strconv.Atoi(strings.TrimSpace(low))
+			if err == nil {
+				codeRange.Max, err = strconv.Atoi(strings.TrimSpace(high))
+			}
+		default:
+			codeRange.Min, err = strconv.Atoi(code)
+			codeRange.Max = codeRange.Min
+		}
+		if err != nil || codeRange.Min < 100 || codeRange.Max > 599 || codeRange.Min > codeRange.Max {
+			return nil, fmt.Errorf("invalid status code %q", code)
+		}
+		ranges = append(ranges, codeRange)
+	}
+	return ranges, nil
+}
+
+func matchesStatusCode(ranges []StatusCodeRange, statusCode int) bool {
+	for _, codeRange := range ranges {
+		if statusCode >= codeRange.Min && statusCode <= codeRange.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSuccess reports whether a response with the status code completes a delivery.
+func (f *Forwarder) IsSuccess(statusCode int) bool {
+	return matchesStatusCode(f.SuccessStatusRanges, statusCode)
+}
+
+// IsRetryable reports whether an unsuccessful response with the status code should be retried.
+func (f *Forwarder) IsRetryable(statusCode int) bool {
+	return matchesStatusCode(f.RetryableStatusRanges, statusCode)
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseStatusCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []string
+		want  []StatusCodeRange
+		valid bool
+	}{
+		{name: "single code", codes: []string{"404"}, want: []StatusCodeRange{{404, 404}}, valid: true},
+		{name: "class", codes: []string{"5xx"}, want: []StatusCodeRange{{500, 599}}, valid: true},
+		{name: "upper case class", codes: []string{"2XX"}, want: []StatusCodeRange{{200, 299}}, valid: true},
+		{name: "range", codes: []string{"500-504"}, want: []StatusCodeRange{{500, 504}}, valid: true},
+		{name: "range with spaces", codes: []string{" 500 - 504 "}, want: []StatusCodeRange{{500, 504}}, valid: true},
+		{name: "range of one code", codes: []string{"503-503"}, want: []StatusCodeRange{{503, 503}}, valid: true},
+		{name: "mixed", codes: []string{"408", "425-429", "5xx"}, want: []StatusCodeRange{{408, 408}, {425, 429}, {500, 599}}, valid: true},
+		{name: "duplicates are kept", codes: []string{"429", "429", "4xx"}, want: []StatusCodeRange{{429, 429}, {429, 429}, {400, 499}}, valid: true},
+		{name: "lowest and highest codes", codes: []string{"100", "599"}, want: []StatusCodeRange{{100, 100}, {599, 599}}, valid: true},
+		{name: "empty list", codes: []string{}, want: []StatusCodeRange{}, valid: true},
+		{name: "reversed range", codes: []string{"504-500"}},
+		{name: "range below 100", codes: []string{"99-200"}},
+		{name: "range above 599", codes: []string{"500-600"}},
+		{name: "code below 100", codes: []string{"99"}},
+		{name: "code above 599", codes: []string{"600"}},
+		{name: "class 0", codes: []string{"0xx"}},
+		{name: "class 6", codes: []string{"6xx"}},
+		{name: "class with letters", codes: []string{"axx"}},
+		{name: "partial class", codes: []string{"50x"}},
+		{name: "open range", codes: []string{"500-"}},
+		{name: "range without start", codes: []string{"-504"}},
+		{name: "range with a class", codes: []string{"4xx-5xx"}},
+		{name: "empty code", codes: []string{""}},
+		{name: "garbage", codes: []string{"ok"}},
+		{name: "invalid code after valid ones", codes: []string{"200", "5xx", "teapot"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStatusCodes(tt.codes)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("parseStatusCodes(%q) = %v, want an error", tt.codes, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseStatusCodes(%q) failed: %v", tt.codes, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseStatusCodes(%q) = %v, want %v", tt.codes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultStatusCodes(t *testing.T) {
+	success, err := parseStatusCodes(DefaultSuccessStatusCodes)
+	if err != nil {
+		t.Fatalf("parsing the default success codes failed: %v", err)
+	}
+	retryable, err := parseStatusCodes(DefaultRetryableStatusCodes)
+	if err != nil {
+		t.Fatalf("parsing the default retryable codes failed: %v", err)
+	}
+	forwarder := &Forwarder{SuccessStatusRanges: success, RetryableStatusRanges: retryable}
+
+	tests := []struct {
+		statusCode int
+		success    bool
+		retryable  bool
+	}{
+		{statusCode: 200, success: true},
+		{statusCode: 204, success: true},
+		{statusCode: 301},
+		{statusCode: 400},
+		{statusCode: 404},
+		{statusCode: 408, retryable: true},
+		{statusCode: 425, retryable: true},
+		{statusCode: 429, retryable: true},
+		{statusCode: 500, retryable: true},
+		{statusCode: 599, retryable: true},
+	}
+	for _, tt := range tests {
+		if got := forwarder.IsSuccess(tt.statusCode); got != tt.success {
+			t.Errorf("IsSuccess(%d) = %v, want %v", tt.statusCode, got, tt.success)
+		}
+		if got := forwarder.IsRetryable(tt.statusCode); got != tt.retryable {
+			t.Errorf("IsRetryable(%d) = %v, want %v", tt.statusCode, got, tt.retryable)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"text/template"
+
+	"laile/internal/jsonpath"
+)
+
+// TemplateFuncs are the functions available in response and transform templates.
+var TemplateFuncs = template.FuncMap{
+	// json encodes a value, e.g. {{ json .Body.data }}
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	},
+	// get looks up a JSON path in a document, e.g. {{ get "$.data.object.id" .Body }}
+	"get": func(path string, document any) (any, error) {
+		parsed, err := jsonpath.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := parsed.Lookup(document)
+		return value, nil
+	},
+}
+
+// ResponseData is the data available to a service's response body template.
+type ResponseData struct {
+	EventID        int64
+	IdempotencyKey string
+	Duplicate      bool
+	request        *http.Request
+}
+
+func NewResponseData(eventID int64, idempotencyKey string, duplicate bool, request *http.Request) ResponseData {
+	return ResponseData{
+		EventID:        eventID,
+		IdempotencyKey: idempotencyKey,
+		Duplicate:      duplicate,
+		request:        request,
+	}
+}
+
+// Header returns the first value of a header on the incoming request.
+func (d ResponseData) Header(name string) string {
+	return d.request.Header.Get(name)
+}
+
+// Query returns the first value of a query parameter on the incoming request.
+func (d ResponseData) Query(name string) string {
+	return d.request.URL.Query().Get(name)
+}
+
+// sampleResponseData is used to check response templates when the configuration is loaded.
+func sampleResponseData(serviceName string) ResponseData {
+	request := &http.Request{Method: http.MethodPost, Header: http.Header{}, URL: &url.URL{Path: "/" + serviceName}}
+	return NewResponseData(1, "event:v1-1-"+serviceName, false, request)
+}
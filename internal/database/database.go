@@ -21,6 +21,8 @@ type Service interface {
 	Queries() *dbmodels.Queries
 	BeginTx(ctx context.Context) (Transaction, error)
 	GetConn(ctx context.Context) (Connection, error)
+	// Close waits for the connections in use to be released and closes the pool.
+	Close()
 }
 
 func Rollback(ctx context.Context, tx Transaction) {
@@ -71,6 +73,12 @@ func (c dBConnectionConfig) DSN() string {
 }
 
 func New() Service {
+	return NewWithMaxConns(0)
+}
+
+// NewWithMaxConns creates a pool with room for at least maxConns connections. The pool keeps the
+// pgx default when it is larger.
+func NewWithMaxConns(maxConns int32) Service {
 	ctx := context.Background()
 	connectionConfig := dBConnectionConfig{
 		Database: os.Getenv("DB_DATABASE"),
@@ -88,6 +96,7 @@ func New() Service {
 	if err != nil {
 		log.Logger.Error("cannot parse db config", slog.Any("error", err))
 	}
+	config.MaxConns = max(config.MaxConns, maxConns)
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
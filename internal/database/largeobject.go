@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// WriteLargeObject streams r into a new large object inside the transaction and returns its OID.
+func WriteLargeObject(ctx context.Context, tx Transaction, r io.Reader) (uint32, error) {
+	largeObjects := tx.RawTx().LargeObjects()
+	oid, err := largeObjects.Create(ctx, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create large object: %w", err)
+	}
+
+	object, err := largeObjects.Open(ctx, oid, pgx.LargeObjectModeWrite)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open large object %d: %w", oid, err)
+	}
+	defer object.Close()
+
+	if _, err = io.Copy(object, r); err != nil {
+		return 0, fmt.Errorf("failed to write large object %d: %w", oid, err)
+	}
+	return oid, nil
+}
+
+// ReadLargeObject reads the whole large object with the given OID inside the transaction.
+func ReadLargeObject(ctx context.Context, tx Transaction, oid uint32) ([]byte, error) {
+	largeObjects := tx.RawTx().LargeObjects()
+	object, err := largeObjects.Open(ctx, oid, pgx.LargeObjectModeRead)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open large object %d: %w", oid, err)
+	}
+	defer object.Close()
+
+	body, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read large object %d: %w", oid, err)
+	}
+	return body, nil
+}
+
+// DeleteLargeObject unlinks the large object with the given OID inside the transaction.
+func DeleteLargeObject(ctx context.Context, tx Transaction, oid uint32) error {
+	largeObjects := tx.RawTx().LargeObjects()
+	if err := largeObjects.Unlink(ctx, oid); err != nil {
+		return fmt.Errorf("failed to unlink large object %d: %w", oid, err)
+	}
+	return nil
+}
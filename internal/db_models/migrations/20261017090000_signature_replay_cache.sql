@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE signature_replay_cache (
+                                        webhook_service_id VARCHAR NOT NULL,
+                                        replay_key VARCHAR NOT NULL,
+                                        expires_at timestamp with time zone NOT NULL,
+                                        created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+                                        PRIMARY KEY (webhook_service_id, replay_key)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE signature_replay_cache;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE UNIQUE INDEX webhooks_service_name_key ON webhooks (webhook_service_id, name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX webhooks_service_name_key;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhooks ADD COLUMN verified_secret VARCHAR;
+CREATE INDEX webhooks_verified_secret_idx ON webhooks (webhook_service_id, verified_secret, created_at)
+    WHERE verified_secret IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX webhooks_verified_secret_idx;
+ALTER TABLE webhooks DROP COLUMN verified_secret;
+-- +goose StatementEnd
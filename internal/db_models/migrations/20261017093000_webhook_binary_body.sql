@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhooks ALTER COLUMN body TYPE bytea USING convert_to(body, 'UTF8');
+ALTER TABLE webhooks ADD COLUMN content_type VARCHAR;
+ALTER TABLE webhooks ADD COLUMN content_encoding VARCHAR;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE webhooks DROP COLUMN content_encoding;
+ALTER TABLE webhooks DROP COLUMN content_type;
+ALTER TABLE webhooks ALTER COLUMN body TYPE text USING convert_from(body, 'UTF8');
+-- +goose StatementEnd
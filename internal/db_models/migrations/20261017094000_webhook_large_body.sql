@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Bodies above the stream threshold are stored as large objects, body is left empty for them
+ALTER TABLE webhooks ADD COLUMN body_oid BIGINT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE webhooks DROP COLUMN body_oid;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhooks ADD COLUMN dedup_key VARCHAR;
+CREATE INDEX webhooks_dedup_key_idx ON webhooks (webhook_service_id, dedup_key, created_at)
+    WHERE dedup_key IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX webhooks_dedup_key_idx;
+ALTER TABLE webhooks DROP COLUMN dedup_key;
+-- +goose StatementEnd
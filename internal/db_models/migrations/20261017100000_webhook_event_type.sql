@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhooks ADD COLUMN event_type VARCHAR;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE webhooks DROP COLUMN event_type;
+-- +goose StatementEnd
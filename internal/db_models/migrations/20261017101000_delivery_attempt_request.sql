@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE delivery_attempts ADD COLUMN request_body BYTEA;
+ALTER TABLE delivery_attempts ADD COLUMN request_headers JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE delivery_attempts DROP COLUMN request_headers;
+ALTER TABLE delivery_attempts DROP COLUMN request_body;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhook_targets ADD COLUMN status delivery_status NOT NULL DEFAULT 'scheduled';
+UPDATE webhook_targets wt SET status = 'success'
+WHERE EXISTS (SELECT 1 FROM delivery_attempts da WHERE da.target_id = wt.id AND da.status = 'success');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE webhook_targets DROP COLUMN status;
+-- +goose StatementEnd
@@ -0,0 +1,7 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+ALTER TYPE delivery_status ADD VALUE IF NOT EXISTS 'dead_letter';
+
+-- +goose Down
+-- Enum values can't be dropped, so dead-lettered targets fall back to failed
+UPDATE webhook_targets SET status = 'failed' WHERE status = 'dead_letter';
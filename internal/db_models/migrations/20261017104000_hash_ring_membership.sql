@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Members register again with their first heartbeat
+DELETE FROM hash_ring;
+ALTER TABLE hash_ring ADD COLUMN heartbeat_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE hash_ring ADD CONSTRAINT hash_ring_node_name_virtual_id_key UNIQUE (node_name, virtual_id);
+CREATE INDEX delivery_attempts_scheduled_hash_value_idx ON delivery_attempts (hash_value) WHERE status = 'scheduled';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX delivery_attempts_scheduled_hash_value_idx;
+ALTER TABLE hash_ring DROP CONSTRAINT hash_ring_node_name_virtual_id_key;
+ALTER TABLE hash_ring DROP COLUMN heartbeat_at;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhook_targets ADD COLUMN ordering_key text;
+CREATE INDEX webhook_targets_pending_ordering_key_idx ON webhook_targets (forwarder_id, ordering_key, id)
+    WHERE status = 'scheduled' AND ordering_key IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX webhook_targets_pending_ordering_key_idx;
+ALTER TABLE webhook_targets DROP COLUMN ordering_key;
+-- +goose StatementEnd
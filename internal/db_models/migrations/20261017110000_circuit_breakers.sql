@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE circuit_state AS ENUM ('closed', 'open', 'half_open');
+
+CREATE TABLE circuit_breakers (
+    webhook_service_id text NOT NULL,
+    forwarder_id text NOT NULL,
+    state circuit_state NOT NULL DEFAULT 'closed',
+    consecutive_failures integer NOT NULL DEFAULT 0,
+    window_started_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    window_total integer NOT NULL DEFAULT 0,
+    window_failures integer NOT NULL DEFAULT 0,
+    probes integer NOT NULL DEFAULT 0,
+    opened_at timestamptz,
+    retry_at timestamptz,
+    updated_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (webhook_service_id, forwarder_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE circuit_breakers;
+DROP TYPE circuit_state;
+-- +goose StatementEnd
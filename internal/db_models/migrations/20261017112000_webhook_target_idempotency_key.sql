@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE webhook_targets ADD COLUMN idempotency_key text;
+UPDATE webhook_targets wt SET idempotency_key = w.idempotency_key || ':' || wt.forwarder_id
+FROM webhooks w
+WHERE wt.webhook_id = w.id AND w.idempotency_key IS NOT NULL;
+CREATE UNIQUE INDEX webhook_targets_idempotency_key_idx ON webhook_targets (idempotency_key);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX webhook_targets_idempotency_key_idx;
+ALTER TABLE webhook_targets DROP COLUMN idempotency_key;
+-- +goose StatementEnd
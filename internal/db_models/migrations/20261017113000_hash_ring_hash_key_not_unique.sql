@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Virtual nodes are identified by (node_name, virtual_id), two of them may land on the same key
+ALTER TABLE hash_ring DROP CONSTRAINT hash_ring_hash_key_key;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE hash_ring ADD CONSTRAINT hash_ring_hash_key_key UNIQUE (hash_key);
+-- +goose StatementEnd
@@ -1,12 +1,43 @@
+-- Names are unique per service, so a provider message ID used as the name deduplicates retries.
 -- name: InsertWebhookEvent :one
-INSERT INTO webhooks (name, url, webhook_service_id, method, body, headers, query_params)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
+INSERT INTO webhooks (name, url, webhook_service_id, method, body, headers, query_params, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (webhook_service_id, name) DO NOTHING
 RETURNING *;
 
+-- name: GetWebhookByName :one
+SELECT * FROM webhooks
+WHERE webhook_service_id = $1 AND name = $2;
+
+-- Returns the latest event with the key that is still inside the dedup window.
+-- name: GetWebhookByDedupKey :one
+SELECT * FROM webhooks
+WHERE webhook_service_id = $1 AND dedup_key = $2 AND created_at >= $3
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- Serializes concurrent requests with the same dedup key until the transaction ends.
+-- name: LockDedupKey :exec
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(lock_key)::text, 0));
+
+-- Serializes concurrent events with the same ordering key until the transaction ends.
+-- name: LockOrderingKey :exec
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(lock_key)::text, 0));
+
 -- name: SetWebhookIdempotencyKey :exec
 UPDATE webhooks SET idempotency_key = $2
 WHERE id = $1;
 
+-- name: GetSecretUsage :many
+SELECT
+    webhook_service_id,
+    verified_secret::text AS secret_name,
+    max(created_at)::timestamptz AS last_used_at,
+    count(*) AS event_count
+FROM webhooks
+WHERE verified_secret IS NOT NULL
+GROUP BY webhook_service_id, verified_secret;
+
 -- name: GetUnprocessedWebhooks :many
 SELECT * FROM webhooks
 WHERE delivery_status = 'future';
@@ -19,6 +50,17 @@ WHERE webhook_service_id = $1 ORDER BY created_at DESC;
 UPDATE webhooks SET delivery_status = $2
 WHERE id = $1;
 
+-- Targets are failed once their forwarder's retry policy is exhausted.
+-- name: UpdateWebhookTargetStatus :exec
+UPDATE webhook_targets SET status = $2
+WHERE id = $1;
+
+-- Moves a dead-lettered target back to scheduled, no row is returned for any other status.
+-- name: RequeueWebhookTarget :one
+UPDATE webhook_targets SET status = 'scheduled'
+WHERE id = $1 AND status = 'dead_letter'
+RETURNING *;
+
 -- name: GetMostRecentDeliveryAttemptByWebhookId :one
 SELECT * FROM delivery_attempts da
          JOIN webhook_targets wt ON da.target_id = wt.id
@@ -27,24 +69,31 @@ ORDER BY da.created_at DESC
 LIMIT 1;
 
 -- name: InsertWebhookTarget :one
-INSERT INTO webhook_targets (webhook_id, forwarder_id, hash_value)
-VALUES ($1, $2, $3)
+INSERT INTO webhook_targets (webhook_id, forwarder_id, hash_value, ordering_key, idempotency_key)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: MarkWebhookAsScheduled :exec
 UPDATE webhooks SET delivery_status = 'scheduled'
 WHERE id = $1;
 
+-- The hash value is copied from the target, it decides which worker delivers the attempt.
 -- name: ScheduleDeliveryAttempt :one
-INSERT INTO delivery_attempts (target_id, scheduled_for, status)
-VALUES ($1, $2, $3)
+INSERT INTO delivery_attempts (target_id, scheduled_for, status, hash_value)
+VALUES ($1, $2, $3, $4)
 RETURNING *;
 
--- name: GetDueDeliveryAttempts :many
-SELECT da.*, wt.*, w.* FROM delivery_attempts da
+-- name: GetClaimedDeliveryAttempt :one
+SELECT da.*,
+    wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value, wt.status, wt.ordering_key,
+    wt.idempotency_key AS target_idempotency_key,
+    w.id AS event_id, w.name, w.url, w.method, w.body, w.headers, w.query_params, w.webhook_service_id,
+    w.delivery_status, w.created_at AS received_at, w.idempotency_key AS event_idempotency_key,
+    w.verified_secret, w.content_type, w.content_encoding, w.body_oid, w.dedup_key, w.event_type
+FROM delivery_attempts da
     JOIN public.webhook_targets wt on da.target_id = wt.id
     JOIN public.webhooks w on wt.webhook_id = w.id
-WHERE da.status = 'scheduled' AND (da.scheduled_for <= $1 OR da.scheduled_for IS NULL);
+WHERE da.id = $1;
 
 -- name: CountDueDeliveryAttempts :one
 SELECT count(*) FROM delivery_attempts ds
@@ -54,8 +103,11 @@ WHERE ds.status = 'scheduled' AND (ds.scheduled_for <= $1 OR ds.scheduled_for IS
 SELECT count(*) FROM delivery_attempts
 WHERE target_id = $1;
 
+-- The response is only set when the forwarder answered with an unsuccessful status.
 -- name: MarkDeliveryAttemptAsFailed :exec
-UPDATE delivery_attempts SET status = 'failed', executed_at=now(), error_message = $2
+UPDATE delivery_attempts SET
+status = 'failed', executed_at=now(), error_message = $2,
+response_code = $3, response_body = $4, response_headers = $5
 WHERE id = $1;
 
 -- name: MarkDeliveryAttemptAsSuccess :exec
@@ -64,6 +116,11 @@ status = 'success', executed_at=now(),
 response_code = $2, response_body = $3, response_headers = $4
 WHERE id = $1;
 
+-- Records the request as it was sent to the forwarder, after any transform.
+-- name: SetDeliveryAttemptRequest :exec
+UPDATE delivery_attempts SET request_body = $2, request_headers = $3
+WHERE id = $1;
+
 
 -- name: GetDeliveryAttemptsList :many
 SELECT da.*, wt.*, w.*
@@ -97,7 +154,7 @@ SELECT
     wt.forwarder_id,
     wt.created_at,
     w.webhook_service_id,
-    COALESCE(la.status, 'future'::delivery_status) as status,
+    wt.status,
     la.response_code,
     COALESCE(ac.attempt_count, 0) as attempt_count
 FROM webhook_targets wt
@@ -107,7 +164,7 @@ FROM webhook_targets wt
 WHERE
     (@service_id::text = '' OR w.webhook_service_id = @service_id) AND
     (@forwarder_id::text = '' OR wt.forwarder_id = @forwarder_id) AND
-    (@status::text = '' OR la.status = @status::delivery_status) AND
+    (@status::text = '' OR wt.status = @status::delivery_status) AND
     (@cursor::bigint = 0 OR wt.id < @cursor)
 ORDER BY wt.id DESC
 LIMIT @page_size;
@@ -119,7 +176,11 @@ SELECT
     executed_at,
     created_at,
     response_body,
-    status
+    response_code,
+    error_message,
+    status,
+    request_body,
+    request_headers
 FROM delivery_attempts
 WHERE target_id = $1
 ORDER BY created_at DESC;
@@ -136,24 +197,85 @@ FROM webhook_targets wt
 WHERE wt.id = $1
 GROUP BY wt.id, w.webhook_service_id, w.url;
 
+-- Lists targets that ran out of retries, with the error of their last attempt.
+-- name: GetDeadLetterTargets :many
+SELECT
+    wt.id,
+    wt.forwarder_id,
+    wt.created_at,
+    w.webhook_service_id,
+    w.event_type,
+    la.error_message,
+    la.executed_at,
+    (SELECT count(*) FROM delivery_attempts da WHERE da.target_id = wt.id) AS attempt_count
+FROM webhook_targets wt
+         JOIN webhooks w ON wt.webhook_id = w.id
+         LEFT JOIN LATERAL (
+    SELECT error_message, executed_at
+    FROM delivery_attempts
+    WHERE target_id = wt.id
+    ORDER BY created_at DESC
+    LIMIT 1
+    ) la ON true
+WHERE
+    wt.status = 'dead_letter' AND
+    (@service_id::text = '' OR w.webhook_service_id = @service_id) AND
+    (@cursor::bigint = 0 OR wt.id < @cursor)
+ORDER BY wt.id DESC
+LIMIT @page_size;
+
+-- A restarted node takes its virtual nodes back under the same name.
 -- name: RegisterNodeInHashRing :one
 INSERT INTO hash_ring (node_name, virtual_id, hash_key)
 VALUES ($1, $2, $3)
-ON CONFLICT (node_name) DO NOTHING
+ON CONFLICT (node_name, virtual_id) DO UPDATE
+    SET hash_key = EXCLUDED.hash_key, heartbeat_at = NOW()
 RETURNING *;
 
+-- No row is affected once the node expired, it has to register again.
+-- name: TouchHashRingNode :execrows
+UPDATE hash_ring SET heartbeat_at = NOW() WHERE node_name = $1;
+
+-- The timeout is in seconds, compared with the database clock like the heartbeats.
+-- name: ExpireHashRingNodes :execrows
+DELETE FROM hash_ring WHERE heartbeat_at < NOW() - @timeout::integer * INTERVAL '1 second';
+
+-- name: RemoveNodeFromHashRing :exec
+DELETE FROM hash_ring WHERE node_name = $1;
+
+-- Virtual nodes on the same key are ordered by name as well, so every worker agrees on which of
+-- them owns the range after the key.
 -- name: GetSortedHashRing :many
-SELECT * FROM hash_ring ORDER BY hash_key;
+SELECT * FROM hash_ring ORDER BY hash_key, node_name, virtual_id;
 
+-- Attempts for forwarders that are at their max_in_flight stay scheduled for the next round, and so
+-- do attempts whose target waits for an earlier target with the same ordering key.
 -- name: ClaimDeliveryAttempt :one
 UPDATE delivery_attempts
 SET status = 'processing', worker_name = @worker_name, executed_at = NOW()
 WHERE id = (
     SELECT id FROM delivery_attempts
     WHERE status = 'scheduled'
-      AND scheduled_for <= NOW()
+      AND (scheduled_for <= NOW() OR scheduled_for IS NULL)
       AND delivery_attempts.hash_value >= @hash_start
       AND delivery_attempts.hash_value < @hash_end
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND w.webhook_service_id || '-' || wt.forwarder_id = ANY(@busy_forwarders::text[])
+      )
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+            JOIN webhook_targets earlier ON earlier.forwarder_id = wt.forwarder_id
+                AND earlier.ordering_key = wt.ordering_key
+                AND earlier.id < wt.id
+            JOIN webhooks ew ON earlier.webhook_id = ew.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND earlier.status = 'scheduled'
+          AND ew.webhook_service_id = w.webhook_service_id
+      )
     ORDER BY scheduled_for, hash_value
         FOR UPDATE SKIP LOCKED
     LIMIT 1
@@ -168,29 +290,177 @@ SET status = 'processing', worker_name = @worker_name, executed_at = NOW()
 WHERE id = (
     SELECT id FROM delivery_attempts
     WHERE status = 'scheduled'
-      AND scheduled_for <= NOW()
-      AND delivery_attempts.hash_value >= @hash_end
-      OR delivery_attempts.hash_value < @hash_end
+      AND (scheduled_for <= NOW() OR scheduled_for IS NULL)
+      AND (delivery_attempts.hash_value >= @hash_start
+        OR delivery_attempts.hash_value < @hash_end)
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND w.webhook_service_id || '-' || wt.forwarder_id = ANY(@busy_forwarders::text[])
+      )
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+            JOIN webhook_targets earlier ON earlier.forwarder_id = wt.forwarder_id
+                AND earlier.ordering_key = wt.ordering_key
+                AND earlier.id < wt.id
+            JOIN webhooks ew ON earlier.webhook_id = ew.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND earlier.status = 'scheduled'
+          AND ew.webhook_service_id = w.webhook_service_id
+      )
     ORDER BY scheduled_for, hash_value
         FOR UPDATE SKIP LOCKED
     LIMIT 1
 )
 RETURNING *;
 
--- name: ReclaimAbandonedDeliveryAttempts :exec
+-- Attempts are abandoned when their worker left the hash ring and they ran longer than the longest
+-- forwarder timeout, in seconds, or when they run far longer than any delivery is allowed to. A worker
+-- that only missed its heartbeats can still be delivering, so its attempts are left to it until then.
+-- name: ReclaimAbandonedDeliveryAttempts :execrows
+UPDATE delivery_attempts
+SET status = 'scheduled', worker_name = NULL, executed_at = NULL
+WHERE status = 'processing' AND (
+    worker_name IS NULL OR
+    (NOT EXISTS (SELECT 1 FROM hash_ring hr WHERE hr.node_name = delivery_attempts.worker_name) AND
+        executed_at < NOW() - @delivery_timeout::integer * INTERVAL '1 second') OR
+    executed_at < NOW() - INTERVAL '10 minutes'
+);
+
+-- Puts a claimed attempt back without delivering it, so it doesn't count against the retries.
+-- name: ReleaseDeliveryAttempt :exec
 UPDATE delivery_attempts
 SET status = 'scheduled', worker_name = NULL, executed_at = NULL
-WHERE status = 'processing' AND executed_at < NOW() - INTERVAL '10 minutes';
+WHERE id = $1 AND status = 'processing';
 
+-- Puts a claimed attempt back to be delivered after a delay, in seconds. Like a released attempt it
+-- doesn't count against the retries.
+-- name: DelayDeliveryAttempt :exec
+UPDATE delivery_attempts
+SET status = 'scheduled', worker_name = NULL, executed_at = NULL,
+    scheduled_for = NOW() + @delay::double precision * INTERVAL '1 second'
+WHERE id = @id AND status = 'processing';
+
+-- Takes the lock when it's free or when its holder didn't touch it within the lease, in seconds.
+-- No row is returned while another worker holds the lock.
 -- name: AcquireTaskLock :one
-INSERT INTO task_locks (task_name, worker_name, acquired_at)
-VALUES ($1, $2, NOW())
-ON CONFLICT (task_name) DO NOTHING
+INSERT INTO task_locks (task_name, worker_name, acquired_at, touched_at)
+VALUES (@task_name, @worker_name, NOW(), NOW())
+ON CONFLICT (task_name) DO UPDATE
+    SET worker_name = EXCLUDED.worker_name, acquired_at = NOW(), touched_at = NOW()
+    WHERE task_locks.touched_at < NOW() - @lease::integer * INTERVAL '1 second'
 RETURNING *;
 
 -- name: ReleaseTaskLock :exec
 DELETE FROM task_locks
-WHERE task_name = $1;
+WHERE task_name = $1 AND worker_name = $2;
+
+-- No row is affected once another worker took the lock over.
+-- name: TouchLock :execrows
+UPDATE task_locks SET touched_at = NOW() WHERE task_name = $1 AND worker_name = $2;
+
+-- Deletes a batch of delivered events older than the retention period, in days. The event status
+-- follows a single target, so events with targets that are still delivering are kept. The bodies
+-- that were stored as large objects have to be unlinked by the caller.
+-- name: DeleteExpiredWebhooks :many
+DELETE FROM webhooks
+WHERE id IN (
+    SELECT id FROM webhooks
+    WHERE created_at < NOW() - @retention_days::integer * INTERVAL '1 day'
+      AND delivery_status IN ('success', 'failed', 'not_needed')
+      AND NOT EXISTS (
+          SELECT 1 FROM webhook_targets t
+          WHERE t.webhook_id = webhooks.id AND t.status IN ('scheduled', 'processing')
+      )
+    ORDER BY id
+    LIMIT @batch_size
+)
+RETURNING body_oid;
+
+-- name: DeleteExpiredSignatures :execrows
+DELETE FROM signature_replay_cache WHERE expires_at < NOW();
+
+-- Signatures are only remembered until they fall outside the tolerance window, after that the
+-- timestamp check rejects them, so an expired row can be reused.
+-- name: RecordSignature :execrows
+INSERT INTO signature_replay_cache (webhook_service_id, replay_key, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (webhook_service_id, replay_key) DO UPDATE
+    SET expires_at = EXCLUDED.expires_at, created_at = CURRENT_TIMESTAMP
+    WHERE signature_replay_cache.expires_at < NOW();
+
+-- name: GetCircuitBreakers :many
+SELECT * FROM circuit_breakers
+ORDER BY state = 'closed', webhook_service_id, forwarder_id;
+
+-- name: GetTrippedCircuitBreakers :many
+SELECT * FROM circuit_breakers
+WHERE state <> 'closed';
+
+-- Counts a delivery in the breaker of its forwarder. The failure rate is measured over a window,
+-- in seconds, a new one starts with the first delivery after the current window ended.
+-- name: RecordCircuitBreakerResult :one
+INSERT INTO circuit_breakers (webhook_service_id, forwarder_id, consecutive_failures, window_total, window_failures)
+VALUES (@webhook_service_id, @forwarder_id, CASE WHEN @failed::boolean THEN 1 ELSE 0 END, 1,
+        CASE WHEN @failed::boolean THEN 1 ELSE 0 END)
+ON CONFLICT (webhook_service_id, forwarder_id) DO UPDATE SET
+    consecutive_failures = CASE WHEN @failed::boolean THEN circuit_breakers.consecutive_failures + 1 ELSE 0 END,
+    window_started_at = CASE WHEN circuit_breakers.window_started_at < NOW() - @window::integer * INTERVAL '1 second'
+        THEN NOW() ELSE circuit_breakers.window_started_at END,
+    window_total = CASE WHEN circuit_breakers.window_started_at < NOW() - @window::integer * INTERVAL '1 second'
+        THEN 1 ELSE circuit_breakers.window_total + 1 END,
+    window_failures = CASE WHEN circuit_breakers.window_started_at < NOW() - @window::integer * INTERVAL '1 second'
+        THEN 0 ELSE circuit_breakers.window_failures END + CASE WHEN @failed::boolean THEN 1 ELSE 0 END,
+    updated_at = NOW()
+RETURNING *;
+
+-- Opens the breaker for the open duration, in seconds. A breaker that is already open keeps its
+-- retry time, so late results of deliveries that were still running don't extend it.
+-- name: OpenCircuitBreaker :execrows
+UPDATE circuit_breakers SET
+    state = 'open', probes = 0, opened_at = NOW(),
+    retry_at = NOW() + @open_duration::integer * INTERVAL '1 second',
+    window_started_at = NOW(), window_total = 0, window_failures = 0, updated_at = NOW()
+WHERE webhook_service_id = @webhook_service_id AND forwarder_id = @forwarder_id AND state <> 'open';
+
+-- name: CloseCircuitBreaker :execrows
+UPDATE circuit_breakers SET
+    state = 'closed', consecutive_failures = 0, probes = 0, opened_at = NULL, retry_at = NULL,
+    window_started_at = NOW(), window_total = 0, window_failures = 0, updated_at = NOW()
+WHERE webhook_service_id = $1 AND forwarder_id = $2 AND state = 'half_open';
+
+-- Lets a probe delivery through once the retry time of the breaker passed. A half-open breaker
+-- hands out up to max_probes probes, and starts over when they didn't report back within the open
+-- duration, in seconds.
+-- name: AcquireCircuitBreakerProbe :execrows
+UPDATE circuit_breakers SET
+    state = 'half_open',
+    probes = CASE WHEN state = 'open' OR retry_at <= NOW() THEN 1 ELSE probes + 1 END,
+    retry_at = CASE WHEN state = 'open' OR retry_at <= NOW()
+        THEN NOW() + @open_duration::integer * INTERVAL '1 second' ELSE retry_at END,
+    updated_at = NOW()
+WHERE webhook_service_id = @webhook_service_id AND forwarder_id = @forwarder_id
+  AND state <> 'closed'
+  AND (retry_at <= NOW() OR (state = 'half_open' AND probes < @max_probes::integer));
+
+-- Takes a token from the bucket of a forwarder, which refills at rate tokens per second up to
+-- burst. No row is returned while the bucket is empty.
+-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets (webhook_service_id, forwarder_id, tokens, refilled_at)
+VALUES (@webhook_service_id, @forwarder_id, @burst::double precision - 1, NOW())
+ON CONFLICT (webhook_service_id, forwarder_id) DO UPDATE
+    SET tokens = LEAST(@burst::double precision, rate_limit_buckets.tokens +
+            EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.refilled_at)::double precision * @rate::double precision) - 1,
+        refilled_at = NOW()
+    WHERE LEAST(@burst::double precision, rate_limit_buckets.tokens +
+            EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.refilled_at)::double precision * @rate::double precision) >= 1
+RETURNING *;
 
--- name: TouchLock :exec
-UPDATE task_locks SET touched_at = NOW() WHERE task_name = $1;
\ No newline at end of file
+-- Seconds until the bucket of a forwarder refilled to a whole token.
+-- name: GetRateLimitWait :one
+SELECT (GREATEST(1 - (tokens + EXTRACT(EPOCH FROM NOW() - refilled_at)::double precision * @rate::double precision), 0) /
+        @rate::double precision)::double precision AS wait_seconds
+FROM rate_limit_buckets
+WHERE webhook_service_id = @webhook_service_id AND forwarder_id = @forwarder_id;
@@ -0,0 +1,176 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"laile/internal/config"
+	"laile/internal/database"
+	"laile/internal/forwarders"
+	"laile/internal/log"
+	dbmodels "laile/internal/postgresql"
+)
+
+// circuitBreakers is what a round of processEvents knows about the breakers that aren't closed.
+// The breakers live in Postgres, so every worker holds the same forwarders.
+type circuitBreakers struct {
+	tripped map[string]dbmodels.CircuitBreaker // By forwarder hash
+	held    []string                           // Forwarder hashes of the breakers that have no probe to give
+}
+
+// loadCircuitBreakers reads the open and half-open breakers at the start of a round. Breakers of
+// forwarders that no longer have a circuit_breaker table are ignored.
+func loadCircuitBreakers(ctx context.Context, queries *dbmodels.Queries, currentConfig *config.Config) (*circuitBreakers, error) {
+	rows, err := queries.GetTrippedCircuitBreakers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get circuit breakers: %w", err)
+	}
+	breakers := &circuitBreakers{tripped: make(map[string]dbmodels.CircuitBreaker, len(rows))}
+	now := time.Now()
+	for _, breaker := range rows {
+		forwarder, ok := circuitBreakerForwarder(currentConfig, breaker)
+		if !ok {
+			continue
+		}
+		breakers.tripped[forwarder.Hash] = breaker
+		// Until the retry time passes an open breaker has no probe to give, and a half-open one
+		// is waiting on the probes it gave out
+		waiting := breaker.State == dbmodels.CircuitStateOpen ||
+			breaker.Probes >= int32(forwarder.CircuitBreaker.Probes) // #nosec G115: a small count
+		if waiting && breaker.RetryAt.Valid && breaker.RetryAt.Time.After(now) {
+			breakers.held = append(breakers.held, forwarder.Hash)
+		}
+	}
+	return breakers, nil
+}
+
+// allow reports whether a claimed attempt for the forwarder may be delivered, and whether it got a
+// probe. While the breaker isn't closed the attempt needs one of its probes.
+func (b *circuitBreakers) allow(ctx context.Context, queries *dbmodels.Queries, serviceID string,
+	forwarder *config.Forwarder) (allowed bool, probe bool, err error) {
+	if _, tripped := b.tripped[forwarder.Hash]; !tripped {
+		return true, false, nil
+	}
+	acquired, err := queries.AcquireCircuitBreakerProbe(ctx, dbmodels.AcquireCircuitBreakerProbeParams{
+		OpenDuration:     int32(forwarder.CircuitBreaker.OpenDuration), // #nosec G115: a duration in seconds
+		WebhookServiceID: serviceID,
+		ForwarderID:      forwarder.Name,
+		MaxProbes:        int32(forwarder.CircuitBreaker.Probes), // #nosec G115: a small count
+	})
+	if err != nil {
+		return false, false, fmt.Errorf("failed to acquire circuit breaker probe: %w", err)
+	}
+	if acquired == 0 {
+		return false, false, nil
+	}
+	log.Logger.InfoContext(ctx, "Circuit breaker lets a probe delivery through",
+		slog.String("service_id", serviceID),
+		slog.String("forwarder_id", forwarder.Name))
+	return true, true, nil
+}
+
+// recordCircuitBreakerResult counts a delivery in the forwarder's breaker, and opens or closes the
+// breaker when the result calls for it. Only the receiver's answer counts: a failure is an error
+// from the forwarder or a retryable status, while permanent failures show the receiver is up. probe
+// tells whether the delivery got a probe from allow, only those can close the breaker.
+func recordCircuitBreakerResult(ctx context.Context, db database.Service, serviceID string, forwarder *config.Forwarder,
+	probe bool, result *forwarders.DeliveryResult, forwardErr error) {
+	if !forwarder.CircuitBreaker.Enabled() {
+		return
+	}
+	queries := db.Queries()
+	settings := forwarder.CircuitBreaker
+	failed := forwardErr != nil ||
+		(!forwarder.IsSuccess(result.StatusCode) && forwarder.IsRetryable(result.StatusCode))
+
+	breaker, err := queries.RecordCircuitBreakerResult(ctx, dbmodels.RecordCircuitBreakerResultParams{
+		WebhookServiceID: serviceID,
+		ForwarderID:      forwarder.Name,
+		Failed:           failed,
+		Window:           int32(settings.Window), // #nosec G115: a duration in seconds
+	})
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to record circuit breaker result", slog.Any("error", err),
+			slog.String("service_id", serviceID),
+			slog.String("forwarder_id", forwarder.Name))
+		return
+	}
+
+	if !failed {
+		// One successful probe is enough to close the breaker. Deliveries that started before the
+		// breaker opened don't show that the receiver recovered.
+		if breaker.State != dbmodels.CircuitStateHalfOpen || !probe {
+			return
+		}
+		closed, err := queries.CloseCircuitBreaker(ctx, dbmodels.CloseCircuitBreakerParams{
+			WebhookServiceID: serviceID,
+			ForwarderID:      forwarder.Name,
+		})
+		if err != nil {
+			log.Logger.ErrorContext(ctx, "Failed to close circuit breaker", slog.Any("error", err),
+				slog.String("service_id", serviceID),
+				slog.String("forwarder_id", forwarder.Name))
+			return
+		}
+		if closed > 0 {
+			log.Logger.InfoContext(ctx, "Circuit breaker closed",
+				slog.String("service_id", serviceID),
+				slog.String("forwarder_id", forwarder.Name))
+		}
+		return
+	}
+
+	if !shouldOpen(settings, breaker) {
+		return
+	}
+	opened, err := queries.OpenCircuitBreaker(ctx, dbmodels.OpenCircuitBreakerParams{
+		OpenDuration:     int32(settings.OpenDuration), // #nosec G115: a duration in seconds
+		WebhookServiceID: serviceID,
+		ForwarderID:      forwarder.Name,
+	})
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to open circuit breaker", slog.Any("error", err),
+			slog.String("service_id", serviceID),
+			slog.String("forwarder_id", forwarder.Name))
+		return
+	}
+	if opened > 0 {
+		log.Logger.WarnContext(ctx, "Circuit breaker opened, holding deliveries",
+			slog.String("service_id", serviceID),
+			slog.String("forwarder_id", forwarder.Name),
+			slog.String("previous_state", string(breaker.State)),
+			slog.Int("consecutive_failures", int(breaker.ConsecutiveFailures)),
+			slog.Int("window_failures", int(breaker.WindowFailures)),
+			slog.Int("window_total", int(breaker.WindowTotal)),
+			slog.Int("open_duration", settings.OpenDuration))
+	}
+}
+
+// shouldOpen reports whether a failure trips the breaker. A failed probe opens it again right away.
+func shouldOpen(settings config.CircuitBreaker, breaker dbmodels.CircuitBreaker) bool {
+	if breaker.State == dbmodels.CircuitStateHalfOpen {
+		return true
+	}
+	if settings.FailureThreshold > 0 && int(breaker.ConsecutiveFailures) >= settings.FailureThreshold {
+		return true
+	}
+	if settings.FailureRate > 0 && int(breaker.WindowTotal) >= settings.MinRequests {
+		return float64(breaker.WindowFailures)/float64(breaker.WindowTotal) >= settings.FailureRate
+	}
+	return false
+}
+
+// circuitBreakerForwarder finds the forwarder of a breaker, as long as it still has one configured.
+func circuitBreakerForwarder(currentConfig *config.Config, breaker dbmodels.CircuitBreaker) (*config.Forwarder, bool) {
+	service, exists := currentConfig.WebhookServices[breaker.WebhookServiceID]
+	if !exists {
+		return nil, false
+	}
+	forwarder, exists := service.Forwarders[breaker.ForwarderID]
+	if !exists || !forwarder.CircuitBreaker.Enabled() {
+		return nil, false
+	}
+	return &forwarder, true
+}
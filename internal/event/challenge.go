@@ -0,0 +1,95 @@
+package event
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"laile/internal/config"
+)
+
+// ChallengeResponse is the reply to a provider handshake. Handshakes are answered directly and never stored.
+type ChallengeResponse struct {
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+}
+
+// challengeResponder returns a response if the request is a handshake for its provider. body is nil
+// when the request body was spooled to disk, handshakes are never that large.
+type challengeResponder func(service *config.WebhookService, request *http.Request, body []byte) (*ChallengeResponse, bool)
+
+var challengeResponders = map[string]challengeResponder{
+	"slack":           slackChallenge,
+	"meta":            metaChallenge,
+	"microsoft_graph": microsoftGraphChallenge,
+	"dropbox":         dropboxChallenge,
+}
+
+// respondToChallenge checks the request against the challenge mode configured for the service.
+func respondToChallenge(service *config.WebhookService, request *http.Request, body []byte) (*ChallengeResponse, bool) {
+	responder, ok := challengeResponders[service.Challenge]
+	if !ok {
+		return nil, false
+	}
+	return responder(service, request, body)
+}
+
+func plainTextChallenge(value string) *ChallengeResponse {
+	return &ChallengeResponse{
+		StatusCode: http.StatusOK,
+		Headers: http.Header{
+			"Content-Type":           []string{"text/plain; charset=utf-8"},
+			"X-Content-Type-Options": []string{"nosniff"},
+		},
+		Body: []byte(value),
+	}
+}
+
+// slackChallenge answers Slack's url_verification event, sent as a JSON POST when the request URL is saved.
+func slackChallenge(_ *config.WebhookService, request *http.Request, body []byte) (*ChallengeResponse, bool) {
+	if request.Method != http.MethodPost || body == nil {
+		return nil, false
+	}
+	var payload struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Type != "url_verification" {
+		return nil, false
+	}
+	return plainTextChallenge(payload.Challenge), true
+}
+
+// metaChallenge answers the GET verification request sent by Meta (Facebook, Instagram, WhatsApp).
+// The hub.verify_token must match the configured challenge_token.
+func metaChallenge(service *config.WebhookService, request *http.Request, _ []byte) (*ChallengeResponse, bool) {
+	query := request.URL.Query()
+	if request.Method != http.MethodGet || query.Get("hub.mode") != "subscribe" {
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("hub.verify_token")), []byte(service.ChallengeToken)) != 1 {
+		return &ChallengeResponse{
+			StatusCode: http.StatusForbidden,
+			Headers:    http.Header{},
+			Body:       nil,
+		}, true
+	}
+	return plainTextChallenge(query.Get("hub.challenge")), true
+}
+
+// microsoftGraphChallenge answers the validationToken request sent when a Graph subscription is created.
+func microsoftGraphChallenge(_ *config.WebhookService, request *http.Request, _ []byte) (*ChallengeResponse, bool) {
+	if !request.URL.Query().Has("validationToken") {
+		return nil, false
+	}
+	return plainTextChallenge(request.URL.Query().Get("validationToken")), true
+}
+
+// dropboxChallenge answers the GET verification request Dropbox sends to new webhook URIs.
+func dropboxChallenge(_ *config.WebhookService, request *http.Request, _ []byte) (*ChallengeResponse, bool) {
+	if request.Method != http.MethodGet || !request.URL.Query().Has("challenge") {
+		return nil, false
+	}
+	return plainTextChallenge(request.URL.Query().Get("challenge")), true
+}
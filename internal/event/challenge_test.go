@@ -0,0 +1,37 @@
+package event
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"laile/internal/config"
+)
+
+func TestSlackChallenge(t *testing.T) {
+	service := &config.WebhookService{Challenge: "slack"}
+	tests := []struct {
+		name      string
+		method    string
+		body      []byte
+		challenge bool
+	}{
+		{name: "url verification", method: http.MethodPost, body: []byte(`{"type": "url_verification", "challenge": "abc"}`), challenge: true},
+		{name: "event callback", method: http.MethodPost, body: []byte(`{"type": "event_callback"}`)},
+		{name: "not json", method: http.MethodPost, body: []byte("type=url_verification")},
+		{name: "get", method: http.MethodGet, body: []byte(`{"type": "url_verification", "challenge": "abc"}`)},
+		{name: "spooled body", method: http.MethodPost, body: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/hook", nil)
+			response, isChallenge := respondToChallenge(service, request, tt.body)
+			if isChallenge != tt.challenge {
+				t.Fatalf("respondToChallenge() = %v, want %v", isChallenge, tt.challenge)
+			}
+			if tt.challenge && string(response.Body) != "abc" {
+				t.Errorf("challenge response body = %q, want %q", response.Body, "abc")
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"laile/internal/config"
+	"laile/internal/database"
+	"laile/internal/forwarders"
+	dbmodels "laile/internal/postgresql"
+)
+
+// ErrNotDeadLettered is returned when a target that isn't in the dead-letter state is requeued.
+var ErrNotDeadLettered = errors.New("webhook target is not dead-lettered")
+
+// deadLetterMessage is sent to a service's dead-letter forwarder when a target is dead-lettered.
+type deadLetterMessage struct {
+	EventID              int64               `json:"event_id"`
+	TargetID             int64               `json:"target_id"`
+	ServiceID            string              `json:"service_id"`
+	ForwarderID          string              `json:"forwarder_id"`
+	EventType            string              `json:"event_type,omitempty"`
+	IdempotencyKey       string              `json:"idempotency_key,omitempty"`
+	TargetIdempotencyKey string              `json:"target_idempotency_key,omitempty"`
+	ReceivedAt           time.Time           `json:"received_at"`
+	Request              deadLetterRequest   `json:"request"`
+	Attempts             []deadLetterAttempt `json:"attempts"`
+}
+
+// deadLetterRequest is the original webhook request, encoded like the AMQP envelope.
+type deadLetterRequest struct {
+	Method          string              `json:"method"`
+	URL             string              `json:"url"`
+	Headers         map[string][]string `json:"headers"`
+	QueryParams     map[string][]string `json:"query_params"`
+	Body            json.RawMessage     `json:"body,omitempty"`
+	BodyEncoding    string              `json:"body_encoding,omitempty"`
+	ContentType     string              `json:"content_type,omitempty"`
+	ContentEncoding string              `json:"content_encoding,omitempty"`
+}
+
+// deadLetterAttempt is one entry of the failure history, newest first.
+type deadLetterAttempt struct {
+	ID           int64      `json:"id"`
+	Status       string     `json:"status"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	ExecutedAt   *time.Time `json:"executed_at,omitempty"`
+	ResponseCode *int32     `json:"response_code,omitempty"`
+	ResponseBody *string    `json:"response_body,omitempty"`
+	ErrorMessage *string    `json:"error_message,omitempty"`
+}
+
+// sendToDeadLetter forwards a dead-lettered target with its failure history to the dead-letter forwarder.
+func sendToDeadLetter(ctx context.Context, db database.Service, event dbmodels.GetClaimedDeliveryAttemptRow, deadLetter *config.Forwarder) error {
+	body := event.Body
+	if event.BodyOid.Valid {
+		var err error
+		body, err = readLargeBody(ctx, db, event.BodyOid.Int64)
+		if err != nil {
+			return err
+		}
+	}
+
+	attempts, err := db.Queries().GetDeliveryAttemptsByTargetId(ctx, event.TargetID)
+	if err != nil {
+		return fmt.Errorf("failed to get delivery attempts: %w", err)
+	}
+
+	var headers http.Header
+	if err = json.Unmarshal(event.Headers, &headers); err != nil {
+		return fmt.Errorf("failed to unmarshal headers: %w", err)
+	}
+	var queryParams map[string][]string
+	if err = json.Unmarshal(event.QueryParams, &queryParams); err != nil {
+		return fmt.Errorf("failed to unmarshal query params: %w", err)
+	}
+	encodedBody, bodyEncoding, err := forwarders.EncodeEnvelopeBody(&forwarders.DeliveryAttempt{
+		Body:            &body,
+		ContentEncoding: event.ContentEncoding.String,
+	})
+	if err != nil {
+		return err
+	}
+
+	message := deadLetterMessage{
+		EventID:              event.EventID,
+		TargetID:             event.TargetID.Int64,
+		ServiceID:            event.WebhookServiceID,
+		ForwarderID:          event.ForwarderID,
+		EventType:            event.EventType.String,
+		IdempotencyKey:       event.EventIdempotencyKey.String,
+		TargetIdempotencyKey: event.TargetIdempotencyKey.String,
+		ReceivedAt:           event.ReceivedAt.Time,
+		Request: deadLetterRequest{
+			Method: event.Method,
+			URL:    event.Url,
+			// The dead-letter forwarder's header policy applies to the embedded headers as well
+			Headers:         forwarders.FilterHeaders(headers, deadLetter),
+			QueryParams:     queryParams,
+			Body:            encodedBody,
+			BodyEncoding:    bodyEncoding,
+			ContentType:     event.ContentType.String,
+			ContentEncoding: event.ContentEncoding.String,
+		},
+		Attempts: make([]deadLetterAttempt, 0, len(attempts)),
+	}
+	for _, attempt := range attempts {
+		message.Attempts = append(message.Attempts, deadLetterAttempt{
+			ID:           attempt.ID,
+			Status:       string(attempt.Status),
+			ScheduledFor: timeOrNil(attempt.ScheduledFor),
+			ExecutedAt:   timeOrNil(attempt.ExecutedAt),
+			ResponseCode: valueOrNil(attempt.ResponseCode.Int32, attempt.ResponseCode.Valid),
+			ResponseBody: valueOrNil(attempt.ResponseBody.String, attempt.ResponseBody.Valid),
+			ErrorMessage: valueOrNil(attempt.ErrorMessage.String, attempt.ErrorMessage.Valid),
+		})
+	}
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter message: %w", err)
+	}
+
+	deadLetterForwarder, err := forwarders.NewForwarder(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to create dead-letter forwarder: %w", err)
+	}
+	result, err := deadLetterForwarder.Forward(ctx, &forwarders.DeliveryAttempt{
+		Headers:        []byte("{}"),
+		Body:           &messageBytes,
+		ContentType:    "application/json",
+		QueryParams:    []byte("{}"),
+		Method:         http.MethodPost,
+		URL:            deadLetter.URL,
+		IdempotencyKey: NewDeadLetterIdempotencyKey(event.TargetIdempotencyKey.String, event.ID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to forward dead-letter message: %w", err)
+	}
+	if !deadLetter.IsSuccess(result.StatusCode) {
+		return fmt.Errorf("dead-letter forwarder responded with status %d", result.StatusCode)
+	}
+	return nil
+}
+
+// readLargeBody loads a webhook body that was stored as a large object.
+func readLargeBody(ctx context.Context, db database.Service, oid int64) ([]byte, error) {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer database.Rollback(ctx, tx)
+
+	body, err := database.ReadLargeObject(ctx, tx, uint32(oid)) // #nosec G115: the column only holds OIDs
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook body: %w", err)
+	}
+	return body, nil
+}
+
+// RequeueDeadLetter schedules a new delivery attempt for a dead-lettered target. The target gets a
+// single attempt, if it fails again it goes straight back to the dead-letter state.
+func RequeueDeadLetter(ctx context.Context, db database.Service, targetID int64) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer database.Rollback(ctx, tx)
+	queries := tx.Queries()
+
+	target, err := queries.RequeueWebhookTarget(ctx, targetID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotDeadLettered
+	}
+	if err != nil {
+		return fmt.Errorf("failed to requeue webhook target: %w", err)
+	}
+	err = queries.UpdateWebhookDeliveryStatus(ctx, dbmodels.UpdateWebhookDeliveryStatusParams{
+		ID:             target.WebhookID.Int64,
+		DeliveryStatus: dbmodels.DeliveryStatusScheduled,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery status: %w", err)
+	}
+	_, err = queries.ScheduleDeliveryAttempt(ctx, dbmodels.ScheduleDeliveryAttemptParams{
+		TargetID:     pgtype.Int8{Int64: target.ID, Valid: true},
+		ScheduledFor: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		Status:       dbmodels.DeliveryStatusScheduled,
+		HashValue:    target.HashValue,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule delivery attempt: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func timeOrNil(value pgtype.Timestamptz) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+func valueOrNil[T any](value T, valid bool) *T {
+	if !valid {
+		return nil
+	}
+	return &value
+}
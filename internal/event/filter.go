@@ -0,0 +1,54 @@
+package event
+
+import (
+	"net/http"
+	"path"
+
+	"laile/internal/config"
+	"laile/internal/jsonpath"
+)
+
+// matchesFilter reports whether an event should be sent to a forwarder with the given filter.
+// Patterns are validated when the configuration is loaded, so match errors can't happen here.
+func matchesFilter(filter config.Filter, eventType string, request *http.Request, body *payload) bool {
+	if len(filter.EventTypes) > 0 && !matchesAny(filter.EventTypes, eventType) {
+		return false
+	}
+
+	for name, pattern := range filter.Headers {
+		if !matchesAny([]string{pattern}, request.Header.Get(name)) {
+			return false
+		}
+	}
+
+	if len(filter.JSON) == 0 {
+		return true
+	}
+	document, err := body.JSON()
+	if err != nil {
+		return false // Bodies that aren't JSON can't match JSON predicates
+	}
+	for _, predicate := range filter.JSON {
+		jsonPath, err := jsonpath.Parse(predicate.Path)
+		if err != nil {
+			return false
+		}
+		value, found := jsonPath.Lookup(document)
+		if !found {
+			return false
+		}
+		if len(predicate.Equals) > 0 && !matchesAny(predicate.Equals, jsonpath.Format(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
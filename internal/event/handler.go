@@ -7,9 +7,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -67,58 +68,194 @@ func (ws *WebhookService) GetForwarderByID(id string) (*config.Forwarder, bool)
 
 	return &forwarderConfig, true
 }
-func HandleEvent(dbService database.Service, listener string, request *http.Request, serverConfig *config.Config) error {
+
+var (
+	// ErrServiceNotFound is returned when no webhook service is configured for the listener path.
+	ErrServiceNotFound = errors.New("webhook service not found")
+	// ErrUnauthorized is returned when the request fails the service authentication check.
+	ErrUnauthorized = errors.New("webhook request is not authenticated")
+)
+
+// HandleResult tells the listener how to reply to a handled request.
+type HandleResult struct {
+	// Challenge is set when the request was a provider handshake instead of an event.
+	Challenge *ChallengeResponse
+	// Service is the configured service that accepted the event.
+	Service *config.WebhookService
+	// EventID and IdempotencyKey identify the stored event, or the original one for duplicates.
+	EventID        int64
+	IdempotencyKey string
+	// Duplicate is set when the event had already been received.
+	Duplicate bool
+}
+
+func HandleEvent(dbService database.Service, listener string, request *http.Request, serverConfig *config.Config) (*HandleResult, error) {
 	ctx := context.Background()
+
+	configService, exists := GetWebhookServiceByPath(serverConfig, listener)
+	if !exists {
+		return nil, ErrServiceNotFound
+	}
+
+	body, err := readPayload(request, configService.Config.MaxBodyBytes, serverConfig.Settings.StreamThresholdBytes)
+	if errors.Is(err, ErrPayloadTooLarge) {
+		log.Logger.WarnContext(ctx, "rejected oversized webhook request", slog.Any("error", err),
+			slog.String("service_id", configService.ID))
+		return nil, err
+	}
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "failed to read request body", slog.Any("error", err))
+		return nil, fmt.Errorf("webhook listener failed to read request body: %w", err)
+	}
+	defer body.Close()
+
+	// Handshakes are answered before authentication since most providers don't sign them.
+	// They only echo a value back, so nothing is stored or forwarded.
+	if challenge, isChallenge := respondToChallenge(configService.Config, request, body.Bytes()); isChallenge {
+		log.Logger.InfoContext(ctx, "Answered provider challenge",
+			"service_id", configService.ID,
+			"status_code", challenge.StatusCode)
+		return &HandleResult{Challenge: challenge}, nil
+	}
+
+	bodyReader, err := body.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("webhook listener failed to read request body: %w", err)
+	}
+
+	// Verify the request before anything is persisted
+	verification, err := configService.Authenticate(ctx, request, bodyReader)
+	if errors.Is(err, ErrUnauthorized) {
+		log.Logger.WarnContext(ctx, "rejected unauthenticated webhook request", slog.Any("error", err),
+			slog.String("service_id", configService.ID))
+		return nil, err
+	}
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "failed to authenticate webhook request", slog.Any("error", err),
+			slog.String("service_id", configService.ID))
+		return nil, fmt.Errorf("webhook listener failed to authenticate request: %w", err)
+	}
+
+	dedupKey, err := extractKey(configService.Config.Dedup.KeySource, request, body)
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "failed to extract dedup key", slog.Any("error", err),
+			slog.String("service_id", configService.ID))
+		return nil, fmt.Errorf("webhook listener failed to extract dedup key: %w", err)
+	}
+
+	eventType, err := extractKey(configService.Config.EventType, request, body)
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "failed to extract event type", slog.Any("error", err),
+			slog.String("service_id", configService.ID))
+		return nil, fmt.Errorf("webhook listener failed to extract event type: %w", err)
+	}
+
 	tx, err := dbService.BeginTx(ctx)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Failed to begin transaction", "error", err)
-		return fmt.Errorf("webhook listener failed to begin database transaction: %w", err)
+		return nil, fmt.Errorf("webhook listener failed to begin database transaction: %w", err)
 	}
 	queries := tx.Queries()
 	defer database.Rollback(ctx, tx)
 
-	configService, exists := GetWebhookServiceByPath(serverConfig, listener)
-	if !exists {
-		return errors.New("webhook service not found")
+	if verification.ReplayKey != "" {
+		var recorded int64
+		recorded, err = queries.RecordSignature(ctx, dbmodels.RecordSignatureParams{
+			WebhookServiceID: configService.ID,
+			ReplayKey:        verification.ReplayKey,
+			ExpiresAt:        pgtype.Timestamptz{Time: verification.ReplayExpiresAt, Valid: true},
+		})
+		if err != nil {
+			log.Logger.ErrorContext(ctx, "failed to record request signature", slog.Any("error", err),
+				slog.String("service_id", configService.ID))
+			return nil, fmt.Errorf("failed to record request signature: %w", err)
+		}
+		if recorded == 0 {
+			log.Logger.WarnContext(ctx, "rejected replayed webhook request",
+				slog.String("service_id", configService.ID))
+			return nil, fmt.Errorf("%w: signature has already been used", ErrUnauthorized)
+		}
 	}
 
-	payloadBytes, err := io.ReadAll(request.Body)
-	if err != nil {
-		log.Logger.ErrorContext(ctx, "failed to read request body", slog.Any("error", err))
-		return fmt.Errorf("webhook listener failed to read request body: %w", err)
+	if dedupKey != "" {
+		original, isDuplicate, dedupErr := findDuplicateEvent(ctx, queries, configService, dedupKey)
+		if dedupErr != nil {
+			return nil, dedupErr
+		}
+		if isDuplicate {
+			return newDuplicateResult(configService, original), nil
+		}
 	}
 
-	payload := string(payloadBytes)
 	headersJSON := internal.HeadersToJSON(request.Header)
 	headersJSONBytes, err := json.Marshal(headersJSON)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Failed to marshal headers", slog.Any("error", err))
-		return errors.New("failed to parse request headers")
+		return nil, errors.New("failed to parse request headers")
 	}
 
 	queryParamsJSON := internal.QueryParamsToJSON(request.URL.Query())
 	queryParamsJSONBytes, err := json.Marshal(queryParamsJSON)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Failed to marshal query params", slog.Any("error", err))
-		return errors.New("failed to parse request query params")
+		return nil, errors.New("failed to parse request query params")
+	}
+
+	// Large bodies are streamed from the spool file into a large object, and body is left empty
+	payloadBytes := body.Bytes()
+	var bodyOID pgtype.Int8
+	if body.Streamed() {
+		payloadBytes = []byte{}
+		bodyOID, err = storeLargePayload(ctx, tx, body)
+		if err != nil {
+			log.Logger.ErrorContext(ctx, "failed to store large webhook body", slog.Any("error", err),
+				slog.String("service_id", configService.ID),
+				slog.Int64("body_length", body.Size()))
+			return nil, err
+		}
+	}
+
+	// Providers that sign a message ID reuse it on retries, so it doubles as the deduplication key.
+	name := verification.MessageID
+	if name == "" {
+		name = shortuuid.New()
 	}
 
 	webhookRecord, err := queries.InsertWebhookEvent(ctx, dbmodels.InsertWebhookEventParams{
-		Name:        shortuuid.New(),
-		Url:         request.URL.String(),
-		Method:      request.Method,
-		Body:        payload,
-		Headers:     headersJSONBytes,
-		QueryParams: queryParamsJSONBytes,
+		Name:             name,
+		Url:              request.URL.String(),
+		WebhookServiceID: configService.ID,
+		Method:           request.Method,
+		Body:             payloadBytes,
+		Headers:          headersJSONBytes,
+		QueryParams:      queryParamsJSONBytes,
+		VerifiedSecret: pgtype.Text{
+			String: verification.SecretName,
+			Valid:  verification.SecretName != "",
+		},
+		ContentType:     headerText(request.Header, "Content-Type"),
+		ContentEncoding: headerText(request.Header, "Content-Encoding"),
+		BodyOid:         bodyOID,
+		DedupKey:        pgtype.Text{String: dedupKey, Valid: dedupKey != ""},
+		EventType:       pgtype.Text{String: eventType, Valid: eventType != ""},
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		var original dbmodels.Webhook
+		original, err = logDuplicateEvent(ctx, queries, configService.ID, name)
+		if err != nil {
+			return nil, err
+		}
+		return newDuplicateResult(configService, original), nil
+	}
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "failed to insert webhook event into database", slog.Any("error", err))
-		return fmt.Errorf("failed to insert webhook event into database: %w", err)
+		return nil, fmt.Errorf("failed to insert webhook event into database: %w", err)
 	}
 
 	log.Logger.InfoContext(ctx, "Webhook event recorded",
 		"event_id", webhookRecord.ID,
-		"service_id", configService.ID)
+		"service_id", configService.ID,
+		"event_type", eventType)
 
 	idempotencyKey := NewIdempotencyKey(webhookRecord.ID, listener)
 	err = queries.SetWebhookIdempotencyKey(ctx, dbmodels.SetWebhookIdempotencyKeyParams{
@@ -132,17 +269,38 @@ func HandleEvent(dbService database.Service, listener string, request *http.Requ
 		log.Logger.ErrorContext(ctx, "failed to set idempotency key", slog.Any("error", err),
 			slog.Int64("event_id", webhookRecord.ID),
 			slog.String("key", idempotencyKey))
-		return fmt.Errorf("failed to set idempotency key: %w", err)
+		return nil, fmt.Errorf("failed to set idempotency key: %w", err)
 	}
 
 	log.Logger.DebugContext(ctx, "Set idempotency key",
 		"event_id", webhookRecord.ID,
 		"key", idempotencyKey)
 
-	// Create webhook targets for each forwarder immediately
+	// Create webhook targets for each forwarder whose filter matches the event
 	now := time.Now()
+	targetCount := 0
 	forwarderConfigs := configService.Config.Forwarders
-	for name := range forwarderConfigs {
+	// Ordering keys are locked in forwarder order, so concurrent events can't deadlock on them
+	for _, name := range slices.Sorted(maps.Keys(forwarderConfigs)) {
+		forwarderConfig := forwarderConfigs[name]
+		if !matchesFilter(forwarderConfig.Filter, eventType, request, body) {
+			log.Logger.DebugContext(ctx, "Forwarder filter does not match event",
+				"event_id", webhookRecord.ID,
+				"forwarder_id", name,
+				"event_type", eventType)
+			continue
+		}
+		targetCount++
+
+		var orderingKey pgtype.Text
+		orderingKey, err = lockOrderingKey(ctx, queries, &forwarderConfig, request, body)
+		if err != nil {
+			log.Logger.ErrorContext(ctx, "Failed to lock ordering key", slog.Any("error", err),
+				slog.Int64("event_id", webhookRecord.ID),
+				slog.String("forwarder_id", name))
+			return nil, err
+		}
+
 		// Generate a hash value for this target for distributed processing
 		hashValue := hashing.HashKey64Bit(fmt.Sprintf("%d%s", webhookRecord.ID, name))
 
@@ -154,12 +312,17 @@ func HandleEvent(dbService database.Service, listener string, request *http.Requ
 			},
 			ForwarderID: name,
 			HashValue:   int64(hashValue), // #nosec G115: only the bits are relevant when used in the hash ring context
+			OrderingKey: orderingKey,
+			IdempotencyKey: pgtype.Text{
+				String: NewTargetIdempotencyKey(idempotencyKey, name),
+				Valid:  true,
+			},
 		})
 		if err != nil {
 			log.Logger.ErrorContext(ctx, "Failed to insert webhook target", slog.Any("error", err),
 				slog.Int64("event_id", webhookRecord.ID),
 				slog.String("forwarder_id", name))
-			return fmt.Errorf("failed to insert webhook target into database: %w", err)
+			return nil, fmt.Errorf("failed to insert webhook target into database: %w", err)
 		}
 
 		_, err = queries.ScheduleDeliveryAttempt(ctx, dbmodels.ScheduleDeliveryAttemptParams{
@@ -172,29 +335,46 @@ func HandleEvent(dbService database.Service, listener string, request *http.Requ
 				InfinityModifier: 0,
 				Valid:            true,
 			},
-			Status: dbmodels.DeliveryStatusScheduled,
+			Status:    dbmodels.DeliveryStatusScheduled,
+			HashValue: webhookTargetRecord.HashValue,
 		})
 		if err != nil {
 			log.Logger.ErrorContext(ctx, "failed to schedule delivery attempt", slog.Any("error", err),
 				slog.Int64("event_id", webhookRecord.ID),
 				slog.Int64("target_id", webhookTargetRecord.ID))
-			return fmt.Errorf("failed to schedule delivery attempt: %w", err)
+			return nil, fmt.Errorf("failed to schedule delivery attempt: %w", err)
 		}
 	}
 
-	// Mark webhook as scheduled since we've created all the targets
-	err = queries.MarkWebhookAsScheduled(ctx, webhookRecord.ID)
+	if targetCount == 0 {
+		// No forwarder wants the event, it is kept for reference only
+		err = queries.UpdateWebhookDeliveryStatus(ctx, dbmodels.UpdateWebhookDeliveryStatusParams{
+			ID:             webhookRecord.ID,
+			DeliveryStatus: dbmodels.DeliveryStatusNotNeeded,
+		})
+	} else {
+		// Mark webhook as scheduled since we've created all the targets
+		err = queries.MarkWebhookAsScheduled(ctx, webhookRecord.ID)
+	}
 	if err != nil {
-		log.Logger.ErrorContext(ctx, "failed to mark webhook as scheduled in database", slog.Any("error", err),
+		log.Logger.ErrorContext(ctx, "failed to update webhook delivery status in database", slog.Any("error", err),
 			slog.Int64("event_id", webhookRecord.ID))
-		return fmt.Errorf("failed to mark webhook as scheduled in database: %w", err)
+		return nil, fmt.Errorf("failed to update webhook delivery status in database: %w", err)
 	}
 
 	// Commit the transaction
 	err = tx.Commit(ctx)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "failed to commit transaction in webhook listener", slog.Any("error", err))
-		return fmt.Errorf("failed to commit transaction in webhook listener: %w", err)
+		return nil, fmt.Errorf("failed to commit transaction in webhook listener: %w", err)
+	}
+
+	result := &HandleResult{
+		Challenge:      nil,
+		Service:        configService.Config,
+		EventID:        webhookRecord.ID,
+		IdempotencyKey: idempotencyKey,
+		Duplicate:      false,
 	}
 
 	// After successful commit, notify the webhook_tasks_channel
@@ -202,7 +382,7 @@ func HandleEvent(dbService database.Service, listener string, request *http.Requ
 	conn, err := dbService.GetConn(ctx)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Failed to get connection for notification", slog.Any("error", err))
-		return nil // Don't fail the request if notification fails
+		return result, nil // Don't fail the request if notification fails
 	}
 	defer conn.Release()
 	rawConn := conn.RawConn()
@@ -216,7 +396,111 @@ func HandleEvent(dbService database.Service, listener string, request *http.Requ
 			slog.Int64("event_id", webhookRecord.ID))
 	}
 
-	return nil
+	return result, nil
+}
+
+// storeLargePayload copies a spooled body into a large object and returns its OID.
+func storeLargePayload(ctx context.Context, tx database.Transaction, body *payload) (pgtype.Int8, error) {
+	reader, err := body.Reader()
+	if err != nil {
+		return pgtype.Int8{}, err
+	}
+	oid, err := database.WriteLargeObject(ctx, tx, reader)
+	if err != nil {
+		return pgtype.Int8{}, fmt.Errorf("failed to store webhook body: %w", err)
+	}
+	return pgtype.Int8{Int64: int64(oid), Valid: true}, nil
+}
+
+// findDuplicateEvent looks for an event with the same dedup key inside the service's dedup window.
+// The key stays locked until the transaction ends, so concurrent retries can't both be stored.
+func findDuplicateEvent(ctx context.Context, queries *dbmodels.Queries, service *WebhookService, dedupKey string) (dbmodels.Webhook, bool, error) {
+	if err := queries.LockDedupKey(ctx, service.ID+":"+dedupKey); err != nil {
+		log.Logger.ErrorContext(ctx, "failed to lock dedup key", slog.Any("error", err),
+			slog.String("service_id", service.ID))
+		return dbmodels.Webhook{}, false, fmt.Errorf("failed to lock dedup key: %w", err)
+	}
+
+	window := time.Duration(service.Config.Dedup.Window) * time.Second
+	original, err := queries.GetWebhookByDedupKey(ctx, dbmodels.GetWebhookByDedupKeyParams{
+		WebhookServiceID: service.ID,
+		DedupKey:         pgtype.Text{String: dedupKey, Valid: true},
+		CreatedAt:        pgtype.Timestamptz{Time: time.Now().Add(-window), Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return original, false, nil
+	}
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "failed to look up dedup key", slog.Any("error", err),
+			slog.String("service_id", service.ID))
+		return original, false, fmt.Errorf("failed to look up dedup key: %w", err)
+	}
+
+	log.Logger.InfoContext(ctx, "Duplicate webhook event ignored",
+		"event_id", original.ID,
+		"service_id", service.ID,
+		"dedup_key", dedupKey)
+	return original, true, nil
+}
+
+// newDuplicateResult replies to a duplicate with the original event, nothing new is scheduled.
+func newDuplicateResult(service *WebhookService, original dbmodels.Webhook) *HandleResult {
+	return &HandleResult{
+		Challenge:      nil,
+		Service:        service.Config,
+		EventID:        original.ID,
+		IdempotencyKey: original.IdempotencyKey.String,
+		Duplicate:      true,
+	}
+}
+
+// headerText returns a request header as a nullable column value.
+func headerText(header http.Header, name string) pgtype.Text {
+	value := header.Get(name)
+	return pgtype.Text{String: value, Valid: value != ""}
+}
+
+// logDuplicateEvent records that a provider retried a message that was already stored.
+// Duplicates are accepted without scheduling any new deliveries.
+func logDuplicateEvent(ctx context.Context, queries *dbmodels.Queries, serviceID string, name string) (dbmodels.Webhook, error) {
+	original, err := queries.GetWebhookByName(ctx, dbmodels.GetWebhookByNameParams{
+		WebhookServiceID: serviceID,
+		Name:             name,
+	})
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "failed to load original webhook event", slog.Any("error", err),
+			slog.String("service_id", serviceID),
+			slog.String("name", name))
+		return original, fmt.Errorf("failed to load original webhook event: %w", err)
+	}
+
+	log.Logger.InfoContext(ctx, "Duplicate webhook event ignored",
+		"event_id", original.ID,
+		"service_id", serviceID,
+		"name", name)
+	return original, nil
+}
+
+// lockOrderingKey reads the forwarder's ordering key from the request and holds it until the transaction
+// ends. Concurrent events with the same key get their target IDs, which decide the delivery order, in
+// the order they are stored.
+func lockOrderingKey(ctx context.Context, queries *dbmodels.Queries, forwarder *config.Forwarder,
+	request *http.Request, body *payload) (pgtype.Text, error) {
+	if forwarder.OrderingKey.Source == "" {
+		return pgtype.Text{}, nil
+	}
+	key, err := extractKey(forwarder.OrderingKey, request, body)
+	if err != nil {
+		return pgtype.Text{}, fmt.Errorf("failed to extract ordering key: %w", err)
+	}
+	// Events without a key aren't ordered
+	if key == "" {
+		return pgtype.Text{}, nil
+	}
+	if err = queries.LockOrderingKey(ctx, forwarder.Hash+":"+key); err != nil {
+		return pgtype.Text{}, fmt.Errorf("failed to lock ordering key: %w", err)
+	}
+	return pgtype.Text{String: key, Valid: true}, nil
 }
 
 // This is used for distributing work across multiple workers.
@@ -227,14 +511,34 @@ func generateHashValue(webhookID int64, forwarderID string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// ProcessEvents delivers the due events until the context is done. It then stops claiming, waits
+// for the running deliveries up to the shutdown timeout and leaves the hash ring before returning.
 func ProcessEvents(ctx context.Context, db database.Service, currentConfig *config.Config) {
-	ticker := time.NewTicker(config.DefaultTickerInterval)
+	ticker := time.NewTicker(time.Duration(currentConfig.Settings.TickerInterval) * time.Second)
 	defer ticker.Stop()
+	workers := newWorkerPool(currentConfig.Settings.WorkerConcurrency)
+	limiter := newRateLimiter()
+
+	// Nothing is claimed until the first heartbeat computed the ranges of this worker
+	member := newRingMember(db, currentConfig.Settings)
+	if err := member.heartbeat(ctx); err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to join the hash ring", slog.Any("error", err))
+	}
+	defer member.leave(context.WithoutCancel(ctx))
+	maintenanceDone := make(chan struct{})
+	go func() {
+		defer close(maintenanceDone)
+		newMaintenanceScheduler(db, currentConfig, member).run(ctx)
+	}()
+	heartbeatTicker := time.NewTicker(time.Duration(currentConfig.Settings.HeartbeatInterval) * time.Second)
+	defer heartbeatTicker.Stop()
+
 	log.Logger.InfoContext(ctx, "Event processor started")
 	eventChan := make(chan string)
 	err := ListenForEvents(ctx, eventChan, db)
 	if err != nil {
-
+		// The ticker still picks up new events
+		log.Logger.ErrorContext(ctx, "Failed to listen for events", slog.Any("error", err))
 	}
 
 	for {
@@ -245,14 +549,38 @@ func ProcessEvents(ctx context.Context, db database.Service, currentConfig *conf
 				continue
 			}
 			log.Logger.DebugContext(ctx, "Processing scheduled events")
-			if err = processEvents(db, currentConfig); err != nil {
+			if err = processEvents(db, currentConfig, workers, member, limiter); err != nil {
 				log.Logger.ErrorContext(ctx, "Failed to process scheduled events", slog.Any("error", err))
 			}
-		case <-eventChan:
+		case _, ok := <-eventChan:
+			if !ok {
+				// The listener stopped, the ticker still picks up new events
+				eventChan = nil
+				continue
+			}
 			log.Logger.DebugContext(ctx, "Processing event from channel")
-			if err = processEvents(db, currentConfig); err != nil {
+			if err = processEvents(db, currentConfig, workers, member, limiter); err != nil {
 				log.Logger.ErrorContext(ctx, "Failed to process events from channel", slog.Any("error", err))
 			}
+		case <-limiter.wake:
+			log.Logger.DebugContext(ctx, "Processing events delayed by a rate limit")
+			if err = processEvents(db, currentConfig, workers, member, limiter); err != nil {
+				log.Logger.ErrorContext(ctx, "Failed to process rate limited events", slog.Any("error", err))
+			}
+		case <-heartbeatTicker.C:
+			if err = member.heartbeat(ctx); err != nil {
+				log.Logger.ErrorContext(ctx, "Failed to send hash ring heartbeat", slog.Any("error", err))
+			}
+		case <-ctx.Done():
+			shutdownTimeout := time.Duration(currentConfig.Settings.ShutdownTimeout) * time.Second
+			log.Logger.InfoContext(ctx, "Event processor stopping, waiting for running deliveries",
+				slog.Int("in_flight", workers.inFlightCount()))
+			if !workers.drain(shutdownTimeout) {
+				log.Logger.WarnContext(ctx, "Running deliveries did not finish in time and were cancelled")
+			}
+			<-maintenanceDone
+			log.Logger.InfoContext(ctx, "Event processor stopped")
+			return
 		}
 	}
 }
@@ -291,7 +619,11 @@ func ListenForEvents(ctx context.Context, eventChan chan string, db database.Ser
 	return nil
 }
 
-func processEvents(db database.Service, currentConfig *config.Config) error {
+// processEvents claims the due delivery attempts in the worker's hash ring ranges and hands them to
+// the worker pool. Claiming marks an attempt as processing, so no other round or worker delivers it
+// while it runs.
+func processEvents(db database.Service, currentConfig *config.Config, workers *workerPool, member *ringMember,
+	limiter *rateLimiter) error {
 	queries := db.Queries()
 	ctx := context.Background()
 	now := time.Now()
@@ -304,140 +636,387 @@ func processEvents(db database.Service, currentConfig *config.Config) error {
 
 	log.Logger.InfoContext(ctx, "Found events to deliver", "count", count)
 
-	events, err := queries.GetDueDeliveryAttempts(ctx, pgtype.Timestamptz{Time: now, Valid: true})
+	breakers, err := loadCircuitBreakers(ctx, queries, currentConfig)
 	if err != nil {
-		log.Logger.ErrorContext(ctx, "failed to get due delivery attempts", slog.Any("error", err))
-		return fmt.Errorf("failed to get due delivery attempts from database: %w", err)
+		return err
 	}
+	// Forwarders that aren't claimed for the rest of the round
+	held := slices.Clone(breakers.held)
 
-	for _, event := range events {
-		log.Logger.DebugContext(ctx, "Processing event", "event_id", event.ID)
-
-		webhookServiceConfig, exists := currentConfig.WebhookServices[event.WebhookServiceID]
-		if !exists {
-			log.Logger.ErrorContext(ctx, "Webhook service not found",
-				slog.Any("error", errors.New("webhook service not found")),
-				slog.String("service_id", event.WebhookServiceID),
-				slog.Int64("event_id", event.ID))
-			continue
-		}
-		forwarderConfig, exists := webhookServiceConfig.Forwarders[event.ForwarderID]
-		if !exists {
-			log.Logger.ErrorContext(ctx, "Forwarder not found",
-				slog.Any("error", errors.New("forwarder not found")),
-				slog.String("service_id", event.WebhookServiceID),
-				slog.String("forwarder_id", event.ForwarderID),
-				slog.Int64("event_id", event.ID))
-			continue
-		}
-		if err = deliverEvent(event, db, &forwarderConfig); err != nil {
-			log.Logger.ErrorContext(ctx, "Failed to deliver event", slog.Any("error", err),
-				slog.Int64("event_id", event.ID),
-				slog.String("forwarder_id", event.ForwarderID))
+	for _, ownedRange := range member.currentRanges() {
+		for !workers.full() {
+			attempt, err := member.claim(ctx, queries, ownedRange, append(workers.busyForwarders(), held...))
+			if errors.Is(err, pgx.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				log.Logger.ErrorContext(ctx, "Failed to claim delivery attempt", slog.Any("error", err))
+				return fmt.Errorf("failed to claim delivery attempt: %w", err)
+			}
+			event, err := queries.GetClaimedDeliveryAttempt(ctx, attempt.ID)
+			if err != nil {
+				return fmt.Errorf("failed to get claimed delivery attempt: %w", err)
+			}
+			log.Logger.DebugContext(ctx, "Processing event", "event_id", event.ID)
 
-			if err = rescheduleEvent(queries, event, err); err != nil {
-				log.Logger.ErrorContext(ctx, "Failed to reschedule event", slog.Any("error", err),
+			webhookServiceConfig, forwarderConfig, err := lookupForwarder(currentConfig, event)
+			if err != nil {
+				log.Logger.ErrorContext(ctx, "Failed to look up forwarder", slog.Any("error", err),
+					slog.String("service_id", event.WebhookServiceID),
+					slog.String("forwarder_id", event.ForwarderID),
 					slog.Int64("event_id", event.ID))
+				if err = deadLetterUnroutable(ctx, db, event, err); err != nil {
+					return err
+				}
+				continue
 			}
+
+			// The token is taken first, so a probe isn't given out to an attempt that has to wait
+			delay, err := limiter.take(ctx, queries, event.WebhookServiceID, forwarderConfig)
+			if err != nil {
+				return err
+			}
+			if delay > 0 {
+				held = append(held, forwarderConfig.Hash)
+				err = queries.DelayDeliveryAttempt(ctx, dbmodels.DelayDeliveryAttemptParams{
+					Delay: delay.Seconds(),
+					ID:    event.ID,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to delay delivery attempt: %w", err)
+				}
+				continue
+			}
+
+			allowed, probe, err := breakers.allow(ctx, queries, event.WebhookServiceID, forwarderConfig)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				held = append(held, forwarderConfig.Hash)
+				// Held attempts go back as they were, waiting doesn't use up their retries
+				if err = queries.ReleaseDeliveryAttempt(ctx, event.ID); err != nil {
+					return fmt.Errorf("failed to release delivery attempt: %w", err)
+				}
+				continue
+			}
+
+			workers.acquire(forwarderConfig)
+			workers.run(forwarderConfig, func(deliveryCtx context.Context) {
+				if err := deliverEvent(deliveryCtx, event, db, forwarderConfig, probe); err != nil {
+					// A delivery cancelled by a shutdown goes back as it was, it doesn't use up a retry
+					if deliveryCtx.Err() != nil {
+						releaseCancelledAttempt(event, db, err)
+						return
+					}
+					log.Logger.ErrorContext(ctx, "Failed to deliver event", slog.Any("error", err),
+						slog.Int64("event_id", event.ID),
+						slog.String("forwarder_id", event.ForwarderID))
+
+					if err = rescheduleEvent(db, event, webhookServiceConfig, forwarderConfig, err); err != nil {
+						log.Logger.ErrorContext(ctx, "Failed to reschedule event", slog.Any("error", err),
+							slog.Int64("event_id", event.ID))
+					}
+				}
+			})
+		}
+		if workers.full() {
+			log.Logger.DebugContext(ctx, "All workers are busy, leaving the remaining events for the next round")
+			break
 		}
 	}
 	return nil
 }
 
-func getNextExponentialBackoffTime(deliveryAttemptCount int64) time.Duration {
-	// 2^deliveryAttemptCount * 1 seconds
-	return (1 << deliveryAttemptCount) * 1 * time.Second
+// lookupForwarder finds the configuration of the service and forwarder of a delivery attempt.
+func lookupForwarder(currentConfig *config.Config, event dbmodels.GetClaimedDeliveryAttemptRow) (*config.WebhookService, *config.Forwarder, error) {
+	webhookServiceConfig, exists := currentConfig.WebhookServices[event.WebhookServiceID]
+	if !exists {
+		return nil, nil, errors.New("webhook service not found")
+	}
+	forwarderConfig, exists := webhookServiceConfig.Forwarders[event.ForwarderID]
+	if !exists {
+		return nil, nil, errors.New("forwarder not found")
+	}
+	return &webhookServiceConfig, &forwarderConfig, nil
 }
 
-func rescheduleEvent(queries *dbmodels.Queries, event dbmodels.GetDueDeliveryAttemptsRow, errorMessage error) error {
-	deliveryAttemptCount, err := queries.GetDeliveryAttemptCount(context.Background(), pgtype.Int8{Int64: event.ID})
+// releaseCancelledAttempt puts an attempt back that was cancelled because the worker shut down, so
+// it is delivered again by the next worker without counting against the retries.
+func releaseCancelledAttempt(event dbmodels.GetClaimedDeliveryAttemptRow, db database.Service, cancelErr error) {
+	ctx := context.Background()
+	log.Logger.WarnContext(ctx, "Delivery cancelled by shutdown, releasing the attempt", slog.Any("error", cancelErr),
+		slog.Int64("event_id", event.ID),
+		slog.String("forwarder_id", event.ForwarderID))
+	if err := db.Queries().ReleaseDeliveryAttempt(ctx, event.ID); err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to release cancelled delivery attempt", slog.Any("error", err),
+			slog.Int64("event_id", event.ID))
+	}
+}
+
+// deadLetterUnroutable finishes a claimed attempt whose service or forwarder is no longer configured.
+// The target is dead-lettered with it, since no attempt would ever deliver it, and a scheduled target
+// would hold back the later events with its ordering key. It can be requeued once the forwarder is back.
+func deadLetterUnroutable(ctx context.Context, db database.Service, event dbmodels.GetClaimedDeliveryAttemptRow,
+	lookupErr error) error {
+	tx, err := db.BeginTx(ctx)
 	if err != nil {
-		return errors.New("failed to get delivery attempt count")
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	nextAttemptTime := time.Now().Add(getNextExponentialBackoffTime(deliveryAttemptCount))
+	defer database.Rollback(ctx, tx)
+	queries := tx.Queries()
+
+	err = queries.MarkDeliveryAttemptAsFailed(ctx, dbmodels.MarkDeliveryAttemptAsFailedParams{
+		ID:           event.ID,
+		ErrorMessage: pgtype.Text{String: lookupErr.Error(), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark delivery attempt as failed: %w", err)
+	}
+	err = queries.UpdateWebhookTargetStatus(ctx, dbmodels.UpdateWebhookTargetStatusParams{
+		ID:     event.TargetID.Int64,
+		Status: dbmodels.DeliveryStatusDeadLetter,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter webhook target: %w", err)
+	}
+	err = queries.UpdateWebhookDeliveryStatus(ctx, dbmodels.UpdateWebhookDeliveryStatusParams{
+		ID:             event.WebhookID.Int64,
+		DeliveryStatus: dbmodels.DeliveryStatusFailed,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery status: %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit dead-lettered target: %w", err)
+	}
+	return nil
+}
+
+// rescheduleEvent records a failed delivery attempt and schedules the next one with the forwarder's
+// retry policy. Once the retries are exhausted, or the response isn't retryable, the target is
+// dead-lettered instead. The attempt and the target change in one transaction, so a target is never
+// left scheduled without an attempt.
+func rescheduleEvent(db database.Service, event dbmodels.GetClaimedDeliveryAttemptRow, serviceConfig *config.WebhookService,
+	forwarderConfig *config.Forwarder, errorMessage error) error {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer database.Rollback(ctx, tx)
+	queries := tx.Queries()
 	queryParams := dbmodels.MarkDeliveryAttemptAsFailedParams{
 		ID:           event.ID,
 		ErrorMessage: pgtype.Text{String: errorMessage.Error(), Valid: true},
 	}
-	err = queries.MarkDeliveryAttemptAsFailed(context.Background(), queryParams)
+	// Unsuccessful responses are stored like successful ones, so the dashboard shows what the forwarder said
+	var responseErr *responseError
+	isResponseErr := errors.As(errorMessage, &responseErr)
+	if isResponseErr {
+		queryParams.ResponseCode, queryParams.ResponseBody, queryParams.ResponseHeaders, err = responseColumns(responseErr.result)
+		if err != nil {
+			return err
+		}
+	}
+	err = queries.MarkDeliveryAttemptAsFailed(ctx, queryParams)
 	if err != nil {
 		return errors.New("failed to mark delivery attempt as failed")
 	}
-	_, err = queries.ScheduleDeliveryAttempt(context.Background(), dbmodels.ScheduleDeliveryAttemptParams{
+
+	deliveryAttemptCount, err := queries.GetDeliveryAttemptCount(ctx, event.TargetID)
+	if err != nil {
+		return errors.New("failed to get delivery attempt count")
+	}
+	permanent := isResponseErr && !responseErr.retryable
+	if permanent || retriesExhausted(forwarderConfig, deliveryAttemptCount) {
+		log.Logger.WarnContext(ctx, "Delivery failed for good, target dead-lettered",
+			slog.Int64("target_id", event.TargetID.Int64),
+			slog.String("forwarder_id", event.ForwarderID),
+			slog.Int64("attempt_count", deliveryAttemptCount),
+			slog.Bool("permanent", permanent))
+		err = queries.UpdateWebhookTargetStatus(ctx, dbmodels.UpdateWebhookTargetStatusParams{
+			ID:     event.TargetID.Int64,
+			Status: dbmodels.DeliveryStatusDeadLetter,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to dead-letter webhook target: %w", err)
+		}
+		err = queries.UpdateWebhookDeliveryStatus(ctx, dbmodels.UpdateWebhookDeliveryStatusParams{
+			ID:             event.WebhookID.Int64,
+			DeliveryStatus: dbmodels.DeliveryStatusFailed,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery status: %w", err)
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to commit dead-lettered target: %w", err)
+		}
+
+		// The target stays visible in the dead-letter view even when the dead-letter forwarder fails
+		if serviceConfig.DeadLetter != nil {
+			if err = sendToDeadLetter(ctx, db, event, serviceConfig.DeadLetter); err != nil {
+				log.Logger.ErrorContext(ctx, "Failed to send event to dead-letter forwarder", slog.Any("error", err),
+					slog.Int64("target_id", event.TargetID.Int64),
+					slog.String("service_id", event.WebhookServiceID))
+			}
+		}
+		return nil
+	}
+
+	now := time.Now()
+	delay := retryDelay(forwarderConfig, deliveryAttemptCount)
+	if isResponseErr {
+		// The receiver knows best when it can take the event, up to the longest delay of the forwarder
+		if requested, ok := retryAfter(responseErr.result, now); ok {
+			maxDelay := time.Duration(forwarderConfig.RetryMaxDelay) * time.Second
+			delay = max(delay, min(requested, maxDelay))
+		}
+	}
+	nextAttemptTime := now.Add(delay)
+	_, err = queries.ScheduleDeliveryAttempt(ctx, dbmodels.ScheduleDeliveryAttemptParams{
 		TargetID:     event.TargetID,
 		ScheduledFor: pgtype.Timestamptz{Time: nextAttemptTime, Valid: true},
 		Status:       dbmodels.DeliveryStatusScheduled,
+		HashValue:    event.HashValue_2,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to schedule delivery attempt: %w", err)
 	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit rescheduled attempt: %w", err)
+	}
 	return nil
 }
 
-func deliverEvent(event dbmodels.GetDueDeliveryAttemptsRow, db database.Service, forwarderConfig *config.Forwarder) error {
-	// Currently there's only one delivery method, HTTP, so we'll just use that
-	const deliveryDuration = 30 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), deliveryDuration)
-	defer cancel()
-
-	tx, err := db.BeginTx(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer database.Rollback(ctx, tx)
+// resultTimeout bounds the writes that record the result of a delivery. They run even when the
+// delivery was cancelled meanwhile, since the event may have gone out.
+const resultTimeout = 10 * time.Second
 
-	queries := db.Queries()
-	queries = queries.WithTx(tx.RawTx())
+// deliverEvent sends a claimed attempt to the forwarder. probe is set when the attempt got a probe of
+// the forwarder's circuit breaker. No database connection is held while the forwarder runs, so slow
+// receivers don't take connections away from the claims and the other deliveries.
+func deliverEvent(parentCtx context.Context, event dbmodels.GetClaimedDeliveryAttemptRow, db database.Service,
+	forwarderConfig *config.Forwarder, probe bool) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Duration(forwarderConfig.Timeouts.Total)*time.Second)
+	defer cancel()
 
 	eventForwarder, err := forwarders.NewForwarder(forwarderConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create event forwarder: %w", err)
 	}
-	log.Logger.InfoContext(ctx, "webhook to deliver", slog.String("body", event.Body))
-	bodyBytes := []byte(event.Body)
-	deliveryAttempt := &forwarders.DeliveryAttempt{
-		Headers:     event.Headers,
-		Body:        &bodyBytes,
-		QueryParams: event.QueryParams,
-		Method:      event.Method,
-		URL:         forwarderConfig.URL,
+	// Bodies above the stream threshold were stored as large objects
+	if event.BodyOid.Valid {
+		event.Body, err = readLargeBody(ctx, db, event.BodyOid.Int64)
+		if err != nil {
+			return err
+		}
+	}
+	log.Logger.InfoContext(ctx, "webhook to deliver", slog.Int("body_length", len(event.Body)),
+		slog.String("content_type", event.ContentType.String))
+	deliveryAttempt := forwarders.NewDeliveryAttempt(event, forwarderConfig)
+
+	if forwarderConfig.Transform.Enabled() {
+		err = applyTransform(&forwarderConfig.Transform, event, deliveryAttempt)
+		if err != nil {
+			return fmt.Errorf("failed to transform event: %w", err)
+		}
+
+		var sentHeaders forwarders.Headers
+		sentHeaders, err = forwarders.OutgoingHeaders(deliveryAttempt, forwarderConfig)
+		if err != nil {
+			return fmt.Errorf("failed to build forwarded headers: %w", err)
+		}
+		var sentHeadersBytes []byte
+		sentHeadersBytes, err = json.Marshal(sentHeaders)
+		if err != nil {
+			return fmt.Errorf("failed to marshal forwarded headers: %w", err)
+		}
+
+		// Recorded before the delivery so failed attempts show what was sent as well
+		err = db.Queries().SetDeliveryAttemptRequest(ctx, dbmodels.SetDeliveryAttemptRequestParams{
+			ID:             event.ID,
+			RequestBody:    *deliveryAttempt.Body,
+			RequestHeaders: sentHeadersBytes,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record transformed request: %w", err)
+		}
 	}
+
 	deliveryResult, err := eventForwarder.Forward(ctx, deliveryAttempt)
+	resultCtx, cancelResult := context.WithTimeout(context.WithoutCancel(ctx), resultTimeout)
+	defer cancelResult()
+	// A delivery cancelled by a shutdown says nothing about the receiver
+	if parentCtx.Err() == nil {
+		recordCircuitBreakerResult(resultCtx, db, event.WebhookServiceID, forwarderConfig, probe, deliveryResult, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to forward event: %w", err)
 	}
+	if !forwarderConfig.IsSuccess(deliveryResult.StatusCode) {
+		return &responseError{
+			result:    deliveryResult,
+			retryable: forwarderConfig.IsRetryable(deliveryResult.StatusCode),
+		}
+	}
+	return markDelivered(resultCtx, db, event, deliveryResult)
+}
 
-	headersBytes, err := json.Marshal(deliveryResult.Headers)
+// markDelivered records a successful delivery on the attempt, its target and its event.
+func markDelivered(ctx context.Context, db database.Service, event dbmodels.GetClaimedDeliveryAttemptRow,
+	deliveryResult *forwarders.DeliveryResult) error {
+	responseCode, responseBody, responseHeaders, err := responseColumns(deliveryResult)
 	if err != nil {
-		return fmt.Errorf("failed to marshal delivery result headers: %w", err)
+		return err
 	}
 
-	var bodyResultSQL pgtype.Text
-	if deliveryResult.Body == nil {
-		bodyResultSQL = pgtype.Text{String: "", Valid: false}
-	} else {
-		bodyResultSQL = pgtype.Text{String: string(*deliveryResult.Body), Valid: true}
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer database.Rollback(ctx, tx)
+	queries := tx.Queries()
 
-	err = queries.MarkDeliveryAttemptAsSuccess(context.Background(), dbmodels.MarkDeliveryAttemptAsSuccessParams{
+	err = queries.MarkDeliveryAttemptAsSuccess(ctx, dbmodels.MarkDeliveryAttemptAsSuccessParams{
 		ID:              event.ID,
-		ResponseCode:    pgtype.Int4{Int32: int32(deliveryResult.StatusCode), Valid: true},
-		ResponseBody:    bodyResultSQL,
-		ResponseHeaders: headersBytes,
+		ResponseCode:    responseCode,
+		ResponseBody:    responseBody,
+		ResponseHeaders: responseHeaders,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to mark delivery attempt as success: %w", err)
 	}
-	err = queries.UpdateWebhookDeliveryStatus(context.Background(), dbmodels.UpdateWebhookDeliveryStatusParams{
+	err = queries.UpdateWebhookTargetStatus(ctx, dbmodels.UpdateWebhookTargetStatusParams{
+		ID:     event.TargetID.Int64,
+		Status: dbmodels.DeliveryStatusSuccess,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update webhook target status: %w", err)
+	}
+	err = queries.UpdateWebhookDeliveryStatus(ctx, dbmodels.UpdateWebhookDeliveryStatusParams{
 		ID:             event.WebhookID.Int64,
 		DeliveryStatus: dbmodels.DeliveryStatusSuccess,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update webhook delivery status: %w", err)
 	}
-	err = tx.Commit(ctx)
-	if err != nil {
+	if err = tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit event delivery transaction: %w", err)
 	}
 	return nil
 }
+
+// responseColumns converts a forwarder response to the columns stored on its delivery attempt.
+func responseColumns(result *forwarders.DeliveryResult) (pgtype.Int4, pgtype.Text, []byte, error) {
+	headersBytes, err := json.Marshal(result.Headers)
+	if err != nil {
+		return pgtype.Int4{}, pgtype.Text{}, nil, fmt.Errorf("failed to marshal delivery result headers: %w", err)
+	}
+
+	var bodyResultSQL pgtype.Text
+	if result.Body != nil {
+		bodyResultSQL = pgtype.Text{String: string(*result.Body), Valid: true}
+	}
+	return pgtype.Int4{Int32: int32(result.StatusCode), Valid: true}, bodyResultSQL, headersBytes, nil // #nosec G115: status codes are three digits
+}
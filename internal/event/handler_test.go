@@ -0,0 +1,117 @@
+package event
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"laile/internal/config"
+	"laile/internal/database"
+	"laile/internal/log"
+	dbmodels "laile/internal/postgresql"
+)
+
+// errNotStubbed stops a handler at the first query the test database doesn't answer.
+var errNotStubbed = errors.New("query is not stubbed")
+
+// replayCache stands in for the database of the listener. It keeps the signature_replay_cache rows
+// and fails every other query with errNotStubbed.
+type replayCache struct {
+	keys map[string]bool
+}
+
+func (c *replayCache) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if !strings.Contains(sql, "INSERT INTO signature_replay_cache") {
+		return pgconn.CommandTag{}, errNotStubbed
+	}
+	key := fmt.Sprint(args[0], "/", args[1])
+	if c.keys[key] {
+		return pgconn.NewCommandTag("INSERT 0 0"), nil
+	}
+	c.keys[key] = true
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func (c *replayCache) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, errNotStubbed
+}
+
+func (c *replayCache) QueryRow(context.Context, string, ...any) pgx.Row {
+	return errRow{}
+}
+
+type errRow struct{}
+
+func (errRow) Scan(...any) error {
+	return errNotStubbed
+}
+
+type fakeDatabase struct {
+	database.Service
+	queries *dbmodels.Queries
+}
+
+func (d *fakeDatabase) BeginTx(context.Context) (database.Transaction, error) {
+	return &fakeTransaction{queries: d.queries}, nil
+}
+
+type fakeTransaction struct {
+	database.Transaction
+	queries *dbmodels.Queries
+}
+
+func (t *fakeTransaction) Queries() *dbmodels.Queries {
+	return t.queries
+}
+
+func (t *fakeTransaction) Rollback(context.Context) error {
+	return nil
+}
+
+func TestHandleEventRejectsReplayedSignature(t *testing.T) {
+	log.InitLogger()
+	db := &fakeDatabase{queries: dbmodels.New(&replayCache{keys: map[string]bool{}})}
+	serverConfig := &config.Config{
+		Settings: config.Settings{MaxBodyBytes: 1 << 20, StreamThresholdBytes: 1 << 20},
+		WebhookServices: map[string]config.WebhookService{
+			"stripe": {
+				AuthenticationType:    "stripe",
+				AuthenticationHeader:  "Stripe-Signature",
+				AuthenticationSecrets: []config.Secret{{Name: "current", Value: "whsec_test"}},
+				SignatureTolerance:    300,
+				MaxBodyBytes:          1 << 20,
+			},
+		},
+	}
+	const body = `{"id": "evt_123"}`
+	send := func(signedAt int64) error {
+		mac := hmac.New(sha256.New, []byte("whsec_test"))
+		mac.Write([]byte(fmt.Sprintf("%d.%s", signedAt, body)))
+		request := httptest.NewRequest(http.MethodPost, "/stripe", strings.NewReader(body))
+		request.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", signedAt, hex.EncodeToString(mac.Sum(nil))))
+		_, err := HandleEvent(db, "stripe", request, serverConfig)
+		return err
+	}
+
+	// A request that passes the replay check goes on to store the event, which isn't stubbed
+	signedAt := time.Now().Unix()
+	if err := send(signedAt); !errors.Is(err, errNotStubbed) {
+		t.Fatalf("first request error = %v, want it to pass the replay check", err)
+	}
+	if err := send(signedAt); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("replayed request error = %v, want ErrUnauthorized", err)
+	}
+	if err := send(signedAt - 1); !errors.Is(err, errNotStubbed) {
+		t.Fatalf("newly signed request error = %v, want it to pass the replay check", err)
+	}
+}
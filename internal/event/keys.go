@@ -0,0 +1,47 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"laile/internal/config"
+	"laile/internal/jsonpath"
+)
+
+// extractKey reads the key described by source from the request. An empty key means the
+// request doesn't carry one, e.g. because the header is missing or the body isn't JSON.
+func extractKey(source config.KeySource, request *http.Request, body *payload) (string, error) {
+	switch source.Source {
+	case "header":
+		return request.Header.Get(source.Header), nil
+	case "json_path":
+		path, err := jsonpath.Parse(source.JSONPath)
+		if err != nil {
+			return "", err
+		}
+		document, err := body.JSON()
+		if err != nil {
+			return "", nil //nolint:nilerr // Bodies that aren't JSON have no key
+		}
+		value, found := path.Lookup(document)
+		if !found {
+			return "", nil
+		}
+		return jsonpath.Format(value), nil
+	case "body_hash":
+		reader, err := body.Reader()
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		if _, err = io.Copy(h, reader); err != nil {
+			return "", fmt.Errorf("failed to hash request body: %w", err)
+		}
+		return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+	default:
+		return "", nil
+	}
+}
@@ -0,0 +1,247 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"laile/internal/config"
+	"laile/internal/database"
+	"laile/internal/log"
+	dbmodels "laile/internal/postgresql"
+)
+
+// maintenanceLock is the task lock held by the worker that runs the maintenance jobs.
+const maintenanceLock = "maintenance"
+
+// retentionBatchSize is how many expired events are deleted per transaction.
+const retentionBatchSize = 1000
+
+// maintenanceJob is work that only one worker in the cluster should do at a time.
+type maintenanceJob struct {
+	name  string
+	every int // Ticks between two runs
+	run   func(ctx context.Context) error
+}
+
+// maintenanceScheduler elects a leader through a lease in task_locks, and runs the maintenance jobs
+// on the leader. The leader renews the lease on every tick, when it stops doing so another worker
+// takes the lock over once the lease expired.
+type maintenanceScheduler struct {
+	db         database.Service
+	workerName string
+	lease      int32 // Seconds
+	interval   time.Duration
+	jobs       []maintenanceJob
+
+	leader bool
+	ticks  int // Ticks since this worker became the leader
+}
+
+func newMaintenanceScheduler(db database.Service, currentConfig *config.Config, member *ringMember) *maintenanceScheduler {
+	settings := currentConfig.Settings
+	deliveryTimeout := longestDeliveryTimeout(currentConfig)
+	heartbeat := time.Duration(settings.HeartbeatInterval) * time.Second
+	scheduler := &maintenanceScheduler{
+		db:         db,
+		workerName: member.name,
+		// Leases and heartbeats use the same timing as the hash ring
+		lease:    int32(settings.NodeTimeout), // #nosec G115: a timeout in seconds
+		interval: heartbeat,
+	}
+	scheduler.jobs = []maintenanceJob{
+		// Expired nodes go first, so the attempts of a dead worker are reclaimed in the same tick
+		{name: "expire_hash_ring_nodes", every: 1, run: func(ctx context.Context) error {
+			return expireHashRingNodes(ctx, db, scheduler.lease)
+		}},
+		{name: "reclaim_abandoned_attempts", every: 1, run: func(ctx context.Context) error {
+			return reclaimAbandonedAttempts(ctx, db, deliveryTimeout)
+		}},
+		{name: "retention", every: max(int(time.Hour/heartbeat), 1), run: func(ctx context.Context) error {
+			return deleteExpiredData(ctx, db, settings.RetentionDays)
+		}},
+	}
+	return scheduler
+}
+
+// run ticks until the context is done and gives up the lock on the way out.
+func (s *maintenanceScheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	defer s.resign(context.WithoutCancel(ctx))
+
+	for {
+		s.tick(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick renews or acquires the leadership, and runs the jobs that are due when this worker leads.
+func (s *maintenanceScheduler) tick(ctx context.Context) {
+	if !s.elect(ctx) {
+		return
+	}
+	for _, job := range s.jobs {
+		if s.ticks%job.every != 0 {
+			continue
+		}
+		if err := job.run(ctx); err != nil {
+			log.Logger.ErrorContext(ctx, "Maintenance job failed", slog.Any("error", err),
+				slog.String("job", job.name))
+		}
+	}
+	s.ticks++
+}
+
+// elect reports whether this worker holds the maintenance lock after renewing or acquiring it.
+func (s *maintenanceScheduler) elect(ctx context.Context) bool {
+	queries := s.db.Queries()
+	if s.leader {
+		touched, err := queries.TouchLock(ctx, dbmodels.TouchLockParams{
+			TaskName:   maintenanceLock,
+			WorkerName: s.workerName,
+		})
+		if err != nil {
+			log.Logger.ErrorContext(ctx, "Failed to renew maintenance lock", slog.Any("error", err))
+			return false
+		}
+		if touched == 1 {
+			return true
+		}
+		log.Logger.WarnContext(ctx, "Lost the maintenance lock to another worker", slog.String("worker_name", s.workerName))
+		s.leader = false
+	}
+
+	_, err := queries.AcquireTaskLock(ctx, dbmodels.AcquireTaskLockParams{
+		TaskName:   maintenanceLock,
+		WorkerName: s.workerName,
+		Lease:      s.lease,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to acquire maintenance lock", slog.Any("error", err))
+		return false
+	}
+	log.Logger.InfoContext(ctx, "Acquired the maintenance lock", slog.String("worker_name", s.workerName))
+	s.leader = true
+	// A new leader doesn't know when the jobs last ran, so it starts with all of them
+	s.ticks = 0
+	return true
+}
+
+// resign releases the lock, so another worker can take over without waiting for the lease to expire.
+func (s *maintenanceScheduler) resign(ctx context.Context) {
+	if !s.leader {
+		return
+	}
+	err := s.db.Queries().ReleaseTaskLock(ctx, dbmodels.ReleaseTaskLockParams{
+		TaskName:   maintenanceLock,
+		WorkerName: s.workerName,
+	})
+	if err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to release maintenance lock", slog.Any("error", err))
+		return
+	}
+	s.leader = false
+}
+
+func expireHashRingNodes(ctx context.Context, db database.Service, timeout int32) error {
+	expired, err := db.Queries().ExpireHashRingNodes(ctx, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to expire hash ring nodes: %w", err)
+	}
+	if expired > 0 {
+		log.Logger.InfoContext(ctx, "Removed expired virtual nodes from the hash ring", slog.Int64("count", expired))
+	}
+	return nil
+}
+
+func reclaimAbandonedAttempts(ctx context.Context, db database.Service, deliveryTimeout int32) error {
+	reclaimed, err := db.Queries().ReclaimAbandonedDeliveryAttempts(ctx, deliveryTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to reclaim abandoned delivery attempts: %w", err)
+	}
+	if reclaimed > 0 {
+		log.Logger.WarnContext(ctx, "Rescheduled abandoned delivery attempts", slog.Int64("count", reclaimed))
+	}
+	return nil
+}
+
+// longestDeliveryTimeout returns the longest total timeout of the configured forwarders in seconds, and
+// at least the default timeout. An attempt of a worker that left the ring is only reclaimed once it ran
+// that long, so it isn't delivered twice when the worker is still running.
+func longestDeliveryTimeout(currentConfig *config.Config) int32 {
+	longest := config.DefaultTimeout
+	for _, service := range currentConfig.WebhookServices {
+		for _, forwarder := range service.Forwarders {
+			longest = max(longest, forwarder.Timeouts.Total)
+		}
+	}
+	return int32(longest) // #nosec G115: a timeout in seconds
+}
+
+// deleteExpiredData removes expired replay-protection entries, and delivered events once they are
+// older than the retention period.
+func deleteExpiredData(ctx context.Context, db database.Service, retentionDays int) error {
+	signatures, err := db.Queries().DeleteExpiredSignatures(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired signatures: %w", err)
+	}
+	if signatures > 0 {
+		log.Logger.DebugContext(ctx, "Deleted expired signatures", slog.Int64("count", signatures))
+	}
+
+	if retentionDays == 0 {
+		return nil
+	}
+	for {
+		deleted, err := deleteExpiredWebhooks(ctx, db, retentionDays)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			log.Logger.InfoContext(ctx, "Deleted expired events", slog.Int("count", deleted))
+		}
+		if deleted < retentionBatchSize {
+			return nil
+		}
+	}
+}
+
+// deleteExpiredWebhooks deletes one batch of expired events with their large object bodies.
+func deleteExpiredWebhooks(ctx context.Context, db database.Service, retentionDays int) (int, error) {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer database.Rollback(ctx, tx)
+
+	bodyOids, err := tx.Queries().DeleteExpiredWebhooks(ctx, dbmodels.DeleteExpiredWebhooksParams{
+		RetentionDays: int32(retentionDays), // #nosec G115: a number of days
+		BatchSize:     retentionBatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired events: %w", err)
+	}
+	for _, oid := range bodyOids {
+		if !oid.Valid {
+			continue
+		}
+		if err = database.DeleteLargeObject(ctx, tx, uint32(oid.Int64)); err != nil { // #nosec G115: the column only holds OIDs
+			return 0, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit expired events: %w", err)
+	}
+	return len(bodyOids), nil
+}
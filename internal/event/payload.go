@@ -0,0 +1,130 @@
+package event
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"laile/internal/jsonpath"
+)
+
+// ErrPayloadTooLarge is returned when a request body exceeds the size limit of its service.
+var ErrPayloadTooLarge = errors.New("webhook request body is too large")
+
+// payload is a request body that is kept in memory while it is small and spooled to a
+// temporary file once it grows past the stream threshold.
+type payload struct {
+	memory *bytes.Buffer
+	file   *os.File
+	size   int64
+
+	// document caches the decoded JSON body for key extraction and filters
+	document    any
+	documentErr error
+	decoded     bool
+}
+
+// readPayload reads the request body, rejecting bodies larger than limit with ErrPayloadTooLarge.
+// The caller must Close the payload to remove any temporary file.
+func readPayload(request *http.Request, limit int64, threshold int64) (*payload, error) {
+	if request.ContentLength > limit {
+		return nil, fmt.Errorf("%w: content length %d exceeds %d bytes", ErrPayloadTooLarge, request.ContentLength, limit)
+	}
+
+	body := http.MaxBytesReader(nil, request.Body, limit)
+	p := &payload{memory: &bytes.Buffer{}}
+
+	// Read one byte more than the threshold to know whether the body has to be spooled
+	n, err := io.CopyN(p.memory, body, threshold+1)
+	p.size = n
+	if err == nil {
+		err = p.spool(body)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		p.Close()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, fmt.Errorf("%w: body exceeds %d bytes", ErrPayloadTooLarge, limit)
+		}
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return p, nil
+}
+
+// spool moves the buffered bytes to a temporary file and streams the rest of the body after them.
+func (p *payload) spool(body io.Reader) error {
+	file, err := os.CreateTemp("", "laile-payload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create payload spool file: %w", err)
+	}
+	p.file = file
+
+	if _, err = p.memory.WriteTo(file); err != nil {
+		return fmt.Errorf("failed to write payload spool file: %w", err)
+	}
+	p.memory = nil
+
+	n, err := io.Copy(file, body)
+	p.size += n
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Streamed reports whether the body was too large to be kept in memory.
+func (p *payload) Streamed() bool {
+	return p.file != nil
+}
+
+// Size is the length of the body in bytes.
+func (p *payload) Size() int64 {
+	return p.size
+}
+
+// Bytes returns the body when it was kept in memory, and nil when it was spooled.
+func (p *payload) Bytes() []byte {
+	if p.memory == nil {
+		return nil
+	}
+	return p.memory.Bytes()
+}
+
+// Reader returns a reader positioned at the start of the body.
+func (p *payload) Reader() (io.ReadSeeker, error) {
+	if p.file == nil {
+		return bytes.NewReader(p.memory.Bytes()), nil
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind payload spool file: %w", err)
+	}
+	return p.file, nil
+}
+
+// JSON decodes the body as a JSON document once and returns the cached result afterwards.
+func (p *payload) JSON() (any, error) {
+	if p.decoded {
+		return p.document, p.documentErr
+	}
+	p.decoded = true
+
+	reader, err := p.Reader()
+	if err != nil {
+		p.documentErr = err
+		return nil, err
+	}
+	p.document, p.documentErr = jsonpath.Decode(reader)
+	return p.document, p.documentErr
+}
+
+// Close removes the spool file, if there is one.
+func (p *payload) Close() {
+	if p.file == nil {
+		return
+	}
+	_ = p.file.Close()
+	_ = os.Remove(p.file.Name())
+}
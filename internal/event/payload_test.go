@@ -0,0 +1,135 @@
+package event
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newBodyRequest(body string, knownLength bool) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	if !knownLength {
+		request.ContentLength = -1
+	}
+	return request
+}
+
+func TestReadPayloadLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		knownLength bool
+		tooLarge    bool
+	}{
+		{name: "below limit", size: 99, knownLength: true},
+		{name: "at limit", size: 100, knownLength: true},
+		{name: "content length above limit", size: 101, knownLength: true, tooLarge: true},
+		{name: "unknown length at limit", size: 100},
+		{name: "unknown length above limit", size: 101, tooLarge: true},
+		{name: "unknown length above limit while spooling", size: 500, tooLarge: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := readPayload(newBodyRequest(strings.Repeat("a", tt.size), tt.knownLength), 100, 10)
+			if tt.tooLarge {
+				if !errors.Is(err, ErrPayloadTooLarge) {
+					t.Fatalf("readPayload() error = %v, want ErrPayloadTooLarge", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPayload() failed: %v", err)
+			}
+			defer body.Close()
+			if body.Size() != int64(tt.size) {
+				t.Errorf("Size() = %d, want %d", body.Size(), tt.size)
+			}
+		})
+	}
+}
+
+func TestReadPayloadSpooling(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		streamed bool
+	}{
+		{name: "empty", body: ""},
+		{name: "below threshold", body: "short"},
+		{name: "at threshold", body: "0123456789"},
+		{name: "above threshold", body: "0123456789a", streamed: true},
+		{name: "far above threshold", body: strings.Repeat("0123456789", 50), streamed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := readPayload(newBodyRequest(tt.body, false), 1000, 10)
+			if err != nil {
+				t.Fatalf("readPayload() failed: %v", err)
+			}
+			defer body.Close()
+
+			if body.Streamed() != tt.streamed {
+				t.Fatalf("Streamed() = %v, want %v", body.Streamed(), tt.streamed)
+			}
+			if body.Size() != int64(len(tt.body)) {
+				t.Errorf("Size() = %d, want %d", body.Size(), len(tt.body))
+			}
+			if tt.streamed && body.Bytes() != nil {
+				t.Errorf("Bytes() = %q, want nil for a spooled body", body.Bytes())
+			}
+			if !tt.streamed && string(body.Bytes()) != tt.body {
+				t.Errorf("Bytes() = %q, want %q", body.Bytes(), tt.body)
+			}
+			// Reading twice checks that the reader is rewound
+			for range 2 {
+				reader, err := body.Reader()
+				if err != nil {
+					t.Fatalf("Reader() failed: %v", err)
+				}
+				content, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("reading the body failed: %v", err)
+				}
+				if string(content) != tt.body {
+					t.Fatalf("Reader() content = %q, want %q", content, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestPayloadCloseRemovesSpoolFile(t *testing.T) {
+	body, err := readPayload(newBodyRequest(strings.Repeat("a", 100), true), 1000, 10)
+	if err != nil {
+		t.Fatalf("readPayload() failed: %v", err)
+	}
+	if !body.Streamed() {
+		t.Fatal("body was not spooled")
+	}
+	name := body.file.Name()
+	body.Close()
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("spool file %s still exists after Close: %v", name, err)
+	}
+}
+
+func TestPayloadJSON(t *testing.T) {
+	for _, threshold := range []int64{1000, 5} {
+		body, err := readPayload(newBodyRequest(`{"type": "ping"}`, true), 1000, threshold)
+		if err != nil {
+			t.Fatalf("readPayload() failed: %v", err)
+		}
+		document, err := body.JSON()
+		body.Close()
+		if err != nil {
+			t.Fatalf("JSON() with threshold %d failed: %v", threshold, err)
+		}
+		if fields, ok := document.(map[string]any); !ok || fields["type"] != "ping" {
+			t.Errorf("JSON() with threshold %d = %v, want the decoded document", threshold, document)
+		}
+	}
+}
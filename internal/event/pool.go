@@ -0,0 +1,132 @@
+package event
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"laile/internal/config"
+)
+
+// cancelGrace is how long cancelled deliveries get to record their result during a shutdown.
+const cancelGrace = 5 * time.Second
+
+// connectionHeadroom is how many database connections a worker needs besides its deliveries: the
+// claims and rate limits of a round, the ring heartbeat, the maintenance jobs and cancelled releases.
+const connectionHeadroom = 4
+
+// MaxConns returns the size of the database pool a worker needs. A delivery holds at most one
+// connection at a time, so the pool never makes deliveries wait on each other.
+func MaxConns(settings config.Settings) int32 {
+	return int32(settings.WorkerConcurrency + connectionHeadroom) // #nosec G115: a small count
+}
+
+// workerPool runs deliveries concurrently, bounded by a global limit and the max_in_flight limit of
+// each forwarder. Deliveries that don't fit are left scheduled and picked up by a later round.
+type workerPool struct {
+	slots chan struct{}
+	// ctx is passed to every delivery, it is only cancelled when a shutdown runs out of time
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	mu       sync.Mutex
+	inFlight map[string]int // Running deliveries by forwarder hash
+	limits   map[string]int // max_in_flight of the forwarders in inFlight
+}
+
+func newWorkerPool(concurrency int) *workerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerPool{
+		slots:    make(chan struct{}, concurrency),
+		ctx:      ctx,
+		cancel:   cancel,
+		inFlight: make(map[string]int),
+		limits:   make(map[string]int),
+	}
+}
+
+// acquire reserves a worker for a delivery to the forwarder, waiting for one when the pool is full.
+// Attempts are only claimed while the pool has room and for forwarders that aren't busy, so this
+// doesn't wait in practice. Every call has to be paired with release.
+func (p *workerPool) acquire(forwarder *config.Forwarder) {
+	p.slots <- struct{}{}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight[forwarder.Hash]++
+	p.limits[forwarder.Hash] = forwarder.MaxInFlight
+}
+
+func (p *workerPool) release(forwarder *config.Forwarder) {
+	p.mu.Lock()
+	p.inFlight[forwarder.Hash]--
+	if p.inFlight[forwarder.Hash] == 0 {
+		delete(p.inFlight, forwarder.Hash)
+		delete(p.limits, forwarder.Hash)
+	}
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// run starts a delivery on a worker reserved with acquire and releases it when the delivery returns.
+func (p *workerPool) run(forwarder *config.Forwarder, delivery func(ctx context.Context)) {
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		defer p.release(forwarder)
+		delivery(p.ctx)
+	}()
+}
+
+// drain waits up to the timeout for the running deliveries to return, then cancels the ones that
+// are left. It reports whether every delivery finished on its own.
+func (p *workerPool) drain(timeout time.Duration) bool {
+	defer p.cancel()
+	if p.wait(timeout) {
+		return true
+	}
+	p.cancel()
+	p.wait(cancelGrace)
+	return false
+}
+
+func (p *workerPool) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// inFlightCount returns how many deliveries are running.
+func (p *workerPool) inFlightCount() int {
+	return len(p.slots)
+}
+
+// full reports whether every worker is busy.
+func (p *workerPool) full() bool {
+	return len(p.slots) == cap(p.slots)
+}
+
+// busyForwarders returns the hashes of the forwarders at their max_in_flight. A forwarder hash is
+// the service ID and forwarder ID joined with a dash, which the claim queries rebuild from the target.
+func (p *workerPool) busyForwarders() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	busy := make([]string, 0, len(p.limits))
+	for hash, limit := range p.limits {
+		if limit > 0 && p.inFlight[hash] >= limit {
+			busy = append(busy, hash)
+		}
+	}
+	return busy
+}
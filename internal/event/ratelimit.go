@@ -0,0 +1,64 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"laile/internal/config"
+	dbmodels "laile/internal/postgresql"
+)
+
+// rateLimiter spaces out the deliveries to forwarders with a rate_limit. The token buckets live in
+// Postgres, so the limit holds for all workers together.
+type rateLimiter struct {
+	// wake is signalled when an attempt that was delayed by the limit is due, so it doesn't have
+	// to wait for the ticker.
+	wake chan struct{}
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{wake: make(chan struct{}, 1)}
+}
+
+// take reports how long a delivery to the forwarder has to wait, zero when it got a token and can
+// go out right away.
+func (l *rateLimiter) take(ctx context.Context, queries *dbmodels.Queries, serviceID string, forwarder *config.Forwarder) (time.Duration, error) {
+	if !forwarder.RateLimit.Enabled() {
+		return 0, nil
+	}
+	_, err := queries.TakeRateLimitToken(ctx, dbmodels.TakeRateLimitTokenParams{
+		WebhookServiceID: serviceID,
+		ForwarderID:      forwarder.Name,
+		Burst:            float64(forwarder.RateLimit.Burst),
+		Rate:             forwarder.RateLimit.RequestsPerSecond,
+	})
+	if err == nil {
+		return 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	waitSeconds, err := queries.GetRateLimitWait(ctx, dbmodels.GetRateLimitWaitParams{
+		Rate:             forwarder.RateLimit.RequestsPerSecond,
+		WebhookServiceID: serviceID,
+		ForwarderID:      forwarder.Name,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rate limit wait: %w", err)
+	}
+	// Another worker can take the token first, which only delays the attempt once more
+	wait := max(time.Duration(waitSeconds*float64(time.Second)), time.Millisecond)
+	time.AfterFunc(wait, l.signal)
+	return wait, nil
+}
+
+func (l *rateLimiter) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
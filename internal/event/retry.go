@@ -0,0 +1,75 @@
+package event
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"laile/internal/config"
+	"laile/internal/forwarders"
+)
+
+// responseError is returned when a forwarder answered, but not with one of its success status codes.
+type responseError struct {
+	result    *forwarders.DeliveryResult
+	retryable bool
+}
+
+func (e *responseError) Error() string {
+	if e.retryable {
+		return fmt.Sprintf("forwarder responded with status %d", e.result.StatusCode)
+	}
+	return fmt.Sprintf("forwarder responded with status %d, which is not retried", e.result.StatusCode)
+}
+
+// retryDelay returns how long to wait before the given retry of a forwarder, starting at 1 for the
+// retry after the first failed attempt. Delays are capped at the forwarder's maximum before jitter.
+func retryDelay(forwarder *config.Forwarder, retry int64) time.Duration {
+	base := time.Duration(forwarder.RetryBaseDelay) * time.Second
+	maxDelay := time.Duration(forwarder.RetryMaxDelay) * time.Second
+
+	delay := base
+	switch forwarder.RetryDelay {
+	case "linear":
+		delay = base * time.Duration(retry)
+	case "exponential":
+		// Doubling stops at the cap, which also keeps it from overflowing
+		for i := int64(1); i < retry && delay < maxDelay; i++ {
+			delay *= 2
+		}
+	}
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+
+	if forwarder.RetryJitter == "full" {
+		delay = rand.N(delay + 1) // #nosec G404: jitter doesn't need a secure source
+	}
+	return delay
+}
+
+// retriesExhausted reports whether a target has used up its attempts after the given number of them.
+func retriesExhausted(forwarder *config.Forwarder, attemptCount int64) bool {
+	return attemptCount > int64(forwarder.RetryCount)
+}
+
+// retryAfter returns the delay a 429 or 503 response asked for in its Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func retryAfter(result *forwarders.DeliveryResult, now time.Time) (time.Duration, bool) {
+	if result.StatusCode != http.StatusTooManyRequests && result.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := http.Header(result.Headers).Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
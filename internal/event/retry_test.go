@@ -0,0 +1,117 @@
+package event
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"laile/internal/config"
+	"laile/internal/forwarders"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		base     int
+		max      int
+		retry    int64
+		want     time.Duration
+	}{
+		{name: "fixed first retry", strategy: "fixed", base: 5, max: 60, retry: 1, want: 5 * time.Second},
+		{name: "fixed later retry", strategy: "fixed", base: 5, max: 60, retry: 7, want: 5 * time.Second},
+		{name: "linear first retry", strategy: "linear", base: 5, max: 60, retry: 1, want: 5 * time.Second},
+		{name: "linear third retry", strategy: "linear", base: 5, max: 60, retry: 3, want: 15 * time.Second},
+		{name: "linear capped", strategy: "linear", base: 5, max: 60, retry: 20, want: 60 * time.Second},
+		{name: "exponential first retry", strategy: "exponential", base: 2, max: 3600, retry: 1, want: 2 * time.Second},
+		{name: "exponential fourth retry", strategy: "exponential", base: 2, max: 3600, retry: 4, want: 16 * time.Second},
+		{name: "exponential capped", strategy: "exponential", base: 2, max: 10, retry: 4, want: 10 * time.Second},
+		{name: "exponential without overflow", strategy: "exponential", base: 2, max: 3600, retry: 1000, want: 3600 * time.Second},
+		{name: "base above the cap", strategy: "fixed", base: 120, max: 60, retry: 1, want: 60 * time.Second},
+		{name: "zero base falls back to the cap", strategy: "exponential", base: 0, max: 60, retry: 3, want: 60 * time.Second},
+		{name: "linear retry 0 falls back to the cap", strategy: "linear", base: 5, max: 60, retry: 0, want: 60 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarder := &config.Forwarder{
+				RetryDelay:     tt.strategy,
+				RetryBaseDelay: tt.base,
+				RetryMaxDelay:  tt.max,
+				RetryJitter:    "none",
+			}
+			if got := retryDelay(forwarder, tt.retry); got != tt.want {
+				t.Errorf("retryDelay(%d) = %v, want %v", tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayFullJitter(t *testing.T) {
+	forwarder := &config.Forwarder{
+		RetryDelay:     "exponential",
+		RetryBaseDelay: 2,
+		RetryMaxDelay:  10,
+		RetryJitter:    "full",
+	}
+	for range 1000 {
+		// The fourth retry is capped at 10 seconds before the jitter is applied
+		if got := retryDelay(forwarder, 4); got < 0 || got > 10*time.Second {
+			t.Fatalf("retryDelay() = %v, want a delay between 0 and 10s", got)
+		}
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	tests := []struct {
+		retryCount   int
+		attemptCount int64
+		exhausted    bool
+	}{
+		{retryCount: 0, attemptCount: 1, exhausted: true},
+		{retryCount: 1, attemptCount: 1},
+		{retryCount: 1, attemptCount: 2, exhausted: true},
+		{retryCount: 3, attemptCount: 3},
+		{retryCount: 3, attemptCount: 4, exhausted: true},
+	}
+	for _, tt := range tests {
+		forwarder := &config.Forwarder{RetryCount: tt.retryCount}
+		if got := retriesExhausted(forwarder, tt.attemptCount); got != tt.exhausted {
+			t.Errorf("retriesExhausted() with retry_count %d after %d attempts = %v, want %v",
+				tt.retryCount, tt.attemptCount, got, tt.exhausted)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		statusCode int
+		value      string
+		want       time.Duration
+		ok         bool
+	}{
+		{name: "seconds on 429", statusCode: http.StatusTooManyRequests, value: "120", want: 2 * time.Minute, ok: true},
+		{name: "seconds on 503", statusCode: http.StatusServiceUnavailable, value: "30", want: 30 * time.Second, ok: true},
+		{name: "zero seconds", statusCode: http.StatusTooManyRequests, value: "0", want: 0, ok: true},
+		{name: "negative seconds", statusCode: http.StatusTooManyRequests, value: "-5", want: 0, ok: true},
+		{name: "http date", statusCode: http.StatusServiceUnavailable, value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, ok: true},
+		{name: "http date in the past", statusCode: http.StatusTooManyRequests, value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, ok: true},
+		{name: "rfc 850 date", statusCode: http.StatusTooManyRequests, value: "Saturday, 17-Oct-26 12:01:00 GMT", want: time.Minute, ok: true},
+		{name: "invalid value", statusCode: http.StatusTooManyRequests, value: "soon"},
+		{name: "missing header", statusCode: http.StatusTooManyRequests},
+		{name: "other status code", statusCode: http.StatusInternalServerError, value: "120"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string][]string{}
+			if tt.value != "" {
+				headers["Retry-After"] = []string{tt.value}
+			}
+			got, ok := retryAfter(&forwarders.DeliveryResult{StatusCode: tt.statusCode, Headers: headers}, now)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("retryAfter(%d, %q) = %v, %v, want %v, %v", tt.statusCode, tt.value, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/lithammer/shortuuid/v4"
+	"laile/internal/config"
+	"laile/internal/database"
+	"laile/internal/hashing"
+	"laile/internal/log"
+	dbmodels "laile/internal/postgresql"
+)
+
+// hashRange is a part of the hash ring, from start up to but excluding end. The range of the last
+// virtual node in the ring wraps around and continues at the start of the ring.
+type hashRange struct {
+	start int64
+	end   int64
+	wraps bool
+}
+
+// ringMember keeps a worker in the hash ring. Every virtual node owns the range from its own key up
+// to the key of the next node, so when a worker joins or expires its ranges move to the neighbouring
+// nodes and the work is rebalanced with the next heartbeat of each worker.
+type ringMember struct {
+	db           database.Service
+	name         string
+	virtualNodes int
+
+	mu     sync.Mutex
+	ranges []hashRange
+}
+
+func newRingMember(db database.Service, settings config.Settings) *ringMember {
+	name := settings.NodeName
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "worker"
+		}
+		// Workers sharing a host still need their own place in the ring
+		name = fmt.Sprintf("%s-%s", hostname, shortuuid.New()[:8])
+	}
+	return &ringMember{
+		db:           db,
+		name:         name,
+		virtualNodes: settings.VirtualNodes,
+	}
+}
+
+// join registers the virtual nodes of the worker, replacing any that are left from an earlier run
+// under the same name.
+func (m *ringMember) join(ctx context.Context) error {
+	tx, err := m.db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer database.Rollback(ctx, tx)
+	queries := tx.Queries()
+
+	if err = queries.RemoveNodeFromHashRing(ctx, m.name); err != nil {
+		return fmt.Errorf("failed to remove stale virtual nodes: %w", err)
+	}
+	for i := range m.virtualNodes {
+		_, err = queries.RegisterNodeInHashRing(ctx, dbmodels.RegisterNodeInHashRingParams{
+			NodeName:  m.name,
+			VirtualID: int32(i), // #nosec G115: bounded by the virtual_nodes setting
+			HashKey:   virtualNodeKey(m.name, i),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register virtual node %d: %w", i, err)
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit hash ring registration: %w", err)
+	}
+	log.Logger.InfoContext(ctx, "Joined the hash ring",
+		slog.String("node_name", m.name),
+		slog.Int("virtual_nodes", m.virtualNodes))
+	return nil
+}
+
+// heartbeat keeps the worker in the ring and recomputes the ranges it owns from the current members.
+// Workers that stopped sending heartbeats are removed by the maintenance leader.
+func (m *ringMember) heartbeat(ctx context.Context) error {
+	queries := m.db.Queries()
+
+	touched, err := queries.TouchHashRingNode(ctx, m.name)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	if touched != int64(m.virtualNodes) {
+		if touched > 0 {
+			log.Logger.WarnContext(ctx, "Virtual nodes are missing from the hash ring, joining again",
+				slog.String("node_name", m.name),
+				slog.Int64("virtual_nodes", touched))
+		}
+		if err = m.join(ctx); err != nil {
+			return err
+		}
+	}
+
+	ring, err := queries.GetSortedHashRing(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get hash ring: %w", err)
+	}
+	ranges := ownedRanges(ring, m.name)
+	m.mu.Lock()
+	m.ranges = ranges
+	m.mu.Unlock()
+	return nil
+}
+
+// leave removes the worker from the ring, so its ranges move to the other workers right away
+// instead of after the node timeout.
+func (m *ringMember) leave(ctx context.Context) {
+	if err := m.db.Queries().RemoveNodeFromHashRing(ctx, m.name); err != nil {
+		log.Logger.ErrorContext(ctx, "Failed to leave the hash ring", slog.Any("error", err),
+			slog.String("node_name", m.name))
+		return
+	}
+	m.mu.Lock()
+	m.ranges = nil
+	m.mu.Unlock()
+	log.Logger.InfoContext(ctx, "Left the hash ring", slog.String("node_name", m.name))
+}
+
+// ownedRanges returns the ranges of the worker's virtual nodes, it expects the ring sorted by key.
+func ownedRanges(ring []dbmodels.HashRing, name string) []hashRange {
+	var ranges []hashRange
+	for i, node := range ring {
+		if node.NodeName != name {
+			continue
+		}
+		if i+1 < len(ring) {
+			ranges = append(ranges, hashRange{start: node.HashKey, end: ring[i+1].HashKey})
+		} else {
+			ranges = append(ranges, hashRange{start: node.HashKey, end: ring[0].HashKey, wraps: true})
+		}
+	}
+	return ranges
+}
+
+// currentRanges returns the ranges computed by the last heartbeat.
+func (m *ringMember) currentRanges() []hashRange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ranges
+}
+
+// claim hands a due attempt in the range to the worker. Rows claimed by other workers are skipped,
+// so workers with overlapping ranges during a rebalance never claim the same attempt.
+func (m *ringMember) claim(ctx context.Context, queries *dbmodels.Queries, ownedRange hashRange,
+	busyForwarders []string) (dbmodels.DeliveryAttempt, error) {
+	workerName := pgtype.Text{String: m.name, Valid: true}
+	if ownedRange.wraps {
+		return queries.ClaimDeliveryAttemptFromEnd(ctx, dbmodels.ClaimDeliveryAttemptFromEndParams{
+			WorkerName:     workerName,
+			HashStart:      ownedRange.start,
+			HashEnd:        ownedRange.end,
+			BusyForwarders: busyForwarders,
+		})
+	}
+	return queries.ClaimDeliveryAttempt(ctx, dbmodels.ClaimDeliveryAttemptParams{
+		WorkerName:     workerName,
+		HashStart:      ownedRange.start,
+		HashEnd:        ownedRange.end,
+		BusyForwarders: busyForwarders,
+	})
+}
+
+// virtualNodeKey places a virtual node on the ring, stored with the same bits as the target hash values.
+func virtualNodeKey(name string, virtualID int) int64 {
+	return int64(hashing.HashKey64Bit(fmt.Sprintf("%s-%d", name, virtualID))) // #nosec G115: only the bits are relevant
+}
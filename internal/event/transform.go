@@ -0,0 +1,120 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"laile/internal/config"
+	"laile/internal/forwarders"
+	"laile/internal/jsonpath"
+	dbmodels "laile/internal/postgresql"
+)
+
+// transformData is the data available to forwarder transform templates.
+type transformData struct {
+	// Body is the decoded JSON body, or nil when the body isn't JSON
+	Body        any
+	RawBody     string
+	Headers     http.Header
+	QueryParams map[string][]string
+	EventID     int64
+	EventType   string
+	ServiceID   string
+	ForwarderID string
+	// IdempotencyKey is the target's key, the one sent to the forwarder
+	IdempotencyKey string
+	ReceivedAt     time.Time
+}
+
+// Header returns the first value of a header from the stored request.
+func (d transformData) Header(name string) string {
+	return d.Headers.Get(name)
+}
+
+// Query returns the first value of a query parameter from the stored request.
+func (d transformData) Query(name string) string {
+	if values := d.QueryParams[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// applyTransform rewrites the body and headers of a delivery attempt with the forwarder transform.
+func applyTransform(transform *config.Transform, event dbmodels.GetClaimedDeliveryAttemptRow, attempt *forwarders.DeliveryAttempt) error {
+	var headers http.Header
+	if err := json.Unmarshal(attempt.Headers, &headers); err != nil {
+		return fmt.Errorf("failed to unmarshal headers: %w", err)
+	}
+	var queryParams map[string][]string
+	if err := json.Unmarshal(attempt.QueryParams, &queryParams); err != nil {
+		return fmt.Errorf("failed to unmarshal query params: %w", err)
+	}
+
+	var document any
+	if attempt.ContentEncoding == "" {
+		document, _ = jsonpath.Decode(bytes.NewReader(*attempt.Body)) // Bodies that aren't JSON are only available as RawBody
+	}
+	data := transformData{
+		Body:           document,
+		RawBody:        string(*attempt.Body),
+		Headers:        headers,
+		QueryParams:    queryParams,
+		EventID:        event.EventID,
+		EventType:      event.EventType.String,
+		ServiceID:      event.WebhookServiceID,
+		ForwarderID:    event.ForwarderID,
+		IdempotencyKey: event.TargetIdempotencyKey.String,
+		ReceivedAt:     event.ReceivedAt.Time,
+	}
+
+	body := *attempt.Body
+	switch {
+	case transform.BodyTemplate != nil:
+		var rendered bytes.Buffer
+		if err := transform.BodyTemplate.Execute(&rendered, data); err != nil {
+			return fmt.Errorf("failed to render transform body: %w", err)
+		}
+		body = rendered.Bytes()
+	case transform.SelectPath != nil:
+		value, found := transform.SelectPath.Lookup(document)
+		if !found {
+			return fmt.Errorf("transform select path %s not found in the body", transform.SelectPath)
+		}
+		selected, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode selected value: %w", err)
+		}
+		body = selected
+	}
+
+	// The rewritten body is sent uncompressed, so the original framing headers no longer apply
+	if transform.BodyTemplate != nil || transform.SelectPath != nil {
+		headers.Del("Content-Encoding")
+		attempt.ContentEncoding = ""
+		if transform.ContentType != "" {
+			attempt.ContentType = transform.ContentType
+			headers.Set("Content-Type", transform.ContentType)
+		}
+	}
+
+	extraHeaders := make(map[string]string, len(transform.HeaderTemplates))
+	for name, headerTemplate := range transform.HeaderTemplates {
+		var rendered bytes.Buffer
+		if err := headerTemplate.Execute(&rendered, data); err != nil {
+			return fmt.Errorf("failed to render transform header %s: %w", name, err)
+		}
+		extraHeaders[name] = rendered.String()
+	}
+
+	headersBytes, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transformed headers: %w", err)
+	}
+	attempt.Body = &body
+	attempt.Headers = headersBytes
+	attempt.ExtraHeaders = extraHeaders
+	return nil
+}
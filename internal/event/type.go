@@ -1,32 +1,30 @@
 package event
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
-	validation "github.com/go-ozzo/ozzo-validation"
 	"laile/internal/config"
+	"laile/internal/verifiers"
 )
 
-type Event struct {
-	ID        int64
-	WebhookID int64
-	Body      string
+func NewIdempotencyKey(eventID int64, servicePath string) string {
+	return fmt.Sprintf("event:v1-%d-%s", eventID, servicePath)
 }
 
-const (
-	MaxBodyLength = 2056
-)
-
-func (e *Event) Validate() error {
-	return validation.ValidateStruct(e,
-		// Street cannot be empty, and the length must between 5 and 50
-		validation.Field(e.Body, validation.Required, validation.Length(0, MaxBodyLength)),
-	)
+// NewTargetIdempotencyKey derives the key of a target from the key of its event. It is stored with
+// the target, so every attempt and requeue of the target sends the same key.
+func NewTargetIdempotencyKey(eventKey string, forwarderID string) string {
+	return eventKey + ":" + forwarderID
 }
 
-func NewIdempotencyKey(eventID int64, servicePath string) string {
-	return fmt.Sprintf("event:v1-%d-%s", eventID, servicePath)
+// NewDeadLetterIdempotencyKey derives the key of a dead-letter message from the key of the target and
+// its last attempt, so a target that is requeued and dead-lettered again sends a new message.
+func NewDeadLetterIdempotencyKey(targetKey string, attemptID int64) string {
+	return fmt.Sprintf("%s:dead_letter-%d", targetKey, attemptID)
 }
 
 type WebhookService struct {
@@ -36,6 +34,20 @@ type WebhookService struct {
 	Config *config.WebhookService
 }
 
-func (ws *WebhookService) IsAuthenticated(request *http.Request) (bool, error) {
-	return true, nil
+// Authenticate runs the verifier registered for the service authentication type against the request.
+// Requests that fail verification return an error wrapping ErrUnauthorized.
+func (ws *WebhookService) Authenticate(ctx context.Context, request *http.Request, body io.ReadSeeker) (*verifiers.Verification, error) {
+	verifier, err := verifiers.NewVerifier(ws.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verifier for service %s: %w", ws.ID, err)
+	}
+
+	verification, err := verifier.Verify(ctx, request, body)
+	if errors.Is(err, verifiers.ErrUnauthorized) {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify request for service %s: %w", ws.ID, err)
+	}
+	return verification, nil
 }
@@ -1,7 +1,11 @@
 package forwarders
 
 import (
+	"io"
+	"log/slog"
 	"sync"
+
+	"laile/internal/log"
 )
 
 type ConnectionMap struct {
@@ -32,4 +36,39 @@ func (cm *ConnectionMap) SetConnection(key string, connection DeliveryAttemptFor
 	cm.connections[key] = connection
 }
 
+// GetOrCreateConnection retrieves a connection from the map, creating it when there is none yet.
+// Concurrent callers for the same key get the same connection.
+func (cm *ConnectionMap) GetOrCreateConnection(key string, create func() DeliveryAttemptForwarder) DeliveryAttemptForwarder {
+	if conn, ok := cm.GetConnection(key); ok {
+		return conn
+	}
+	cm.Lock()
+	defer cm.Unlock()
+	if conn, ok := cm.connections[key]; ok {
+		return conn
+	}
+	conn := create()
+	cm.connections[key] = conn
+	return conn
+}
+
+// CloseAll closes the connections that hold resources and empties the map.
+func (cm *ConnectionMap) CloseAll() {
+	cm.Lock()
+	defer cm.Unlock()
+	for key, connection := range cm.connections {
+		if closer, ok := connection.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Logger.Error("failed to close forwarder connection", slog.Any("error", err), slog.String("forwarder", key))
+			}
+		}
+		delete(cm.connections, key)
+	}
+}
+
 var globalConnections = NewConnectionMap()
+
+// CloseConnections closes the connections kept open by the forwarders, e.g. AMQP sessions.
+func CloseConnections() {
+	globalConnections.CloseAll()
+}
@@ -6,19 +6,18 @@ import (
 	"laile/internal/config"
 )
 
+// NewForwarder returns the forwarder for the configuration. Forwarders are cached by their hash, so
+// AMQP sessions and HTTP connection pools are shared by every delivery to the same forwarder.
 func NewForwarder(config *config.Forwarder) (DeliveryAttemptForwarder, error) {
 	switch config.Type {
 	case "amqp":
-		connectionKey := config.Hash
-		activeForwarder, ok := globalConnections.GetConnection(connectionKey)
-		if ok {
-			return activeForwarder, nil
-		}
-		activeForwarder = NewRMQForwarder(config)
-		globalConnections.SetConnection(connectionKey, activeForwarder)
-		return activeForwarder, nil
+		return globalConnections.GetOrCreateConnection(config.Hash, func() DeliveryAttemptForwarder {
+			return NewRMQForwarder(config)
+		}), nil
 	case "http":
-		return NewHTTPForwarder(config), nil
+		return globalConnections.GetOrCreateConnection(config.Hash, func() DeliveryAttemptForwarder {
+			return NewHTTPForwarder(config)
+		}), nil
 	default:
 		return nil, errors.New("invalid forwarder type")
 	}
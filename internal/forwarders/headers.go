@@ -0,0 +1,88 @@
+package forwarders
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"laile/internal/config"
+)
+
+// hopByHopHeaders only apply to a single connection and are never forwarded (RFC 9110, section 7.6.1).
+// Host and Content-Length are set by the HTTP client for the outgoing request.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Host",
+	"Content-Length",
+}
+
+// OutgoingHeaders returns the headers a forwarder sends for a delivery attempt. Inbound headers are
+// filtered with the forwarder's header policy before the payload and configured headers are added.
+func OutgoingHeaders(attempt *DeliveryAttempt, forwarder *config.Forwarder) (Headers, error) {
+	inbound, err := getHeadersFromBytes(attempt.Headers)
+	if err != nil {
+		return nil, err
+	}
+	headers := FilterHeaders(http.Header(inbound), forwarder)
+
+	// The payload is forwarded byte for byte, so it keeps the type and encoding it was received with
+	if attempt.ContentType != "" {
+		headers.Set("Content-Type", attempt.ContentType)
+	}
+	if attempt.ContentEncoding != "" {
+		headers.Set("Content-Encoding", attempt.ContentEncoding)
+	}
+
+	for name, value := range attempt.ExtraHeaders {
+		headers.Set(name, value)
+	}
+
+	// Add configured forwarder headers, overwriting any existing ones
+	for name, value := range forwarder.Headers {
+		headers.Set(name, value)
+	}
+	return Headers(headers), nil
+}
+
+// FilterHeaders applies the forwarder's forward_headers and drop_headers lists, and always removes
+// hop-by-hop headers, including the ones named in the Connection header.
+func FilterHeaders(inbound http.Header, forwarder *config.Forwarder) http.Header {
+	dropped := append([]string{}, hopByHopHeaders...)
+	for _, value := range inbound.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			dropped = append(dropped, strings.TrimSpace(name))
+		}
+	}
+	dropped = append(dropped, forwarder.DropHeaders...)
+
+	headers := make(http.Header, len(inbound))
+	for name, values := range inbound {
+		if len(forwarder.ForwardHeaders) > 0 && !matchesHeader(forwarder.ForwardHeaders, name) {
+			continue
+		}
+		if matchesHeader(dropped, name) {
+			continue
+		}
+		headers[http.CanonicalHeaderKey(name)] = values
+	}
+	return headers
+}
+
+// matchesHeader reports whether a header name matches one of the patterns, ignoring case.
+func matchesHeader(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
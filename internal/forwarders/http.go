@@ -1,49 +1,57 @@
 package forwarders
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
-	"strings"
+	"slices"
+	"time"
 
 	"laile/internal"
 	"laile/internal/config"
 	"laile/internal/log"
 )
 
+// keepAliveInterval is how often idle connections to a forwarder are probed.
+const keepAliveInterval = 30 * time.Second
+
+// HTTPForwarder sends events with a client of its own, so connections to the forwarder are kept
+// open and reused between deliveries.
 type HTTPForwarder struct {
 	Config *config.Forwarder
+	client *http.Client
 }
 
 func (f *HTTPForwarder) Forward(ctx context.Context, event *DeliveryAttempt) (*DeliveryResult, error) {
 	log.Logger.DebugContext(ctx, "Preparing to forward request",
-		"body_length", len(string(*event.Body)),
+		"body_length", len(*event.Body),
 		"url", f.Config.URL,
 		"method", event.Method)
 
-	reader := strings.NewReader(string(*event.Body))
+	reader := bytes.NewReader(*event.Body)
 	req, err := http.NewRequestWithContext(ctx, event.Method, f.Config.URL, reader)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Failed to create request", "error", err)
 		return nil, fmt.Errorf("failed to create new request for HTTP forwarder: %w", err)
 	}
 
-	// Copy headers from the original request to the proxy request
-	headers, err := getHeadersFromBytes(event.Headers)
+	// Copy the allowed headers from the original request to the proxy request
+	headers, err := OutgoingHeaders(event, f.Config)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Failed to parse headers", "error", err)
 		return nil, fmt.Errorf("failed to parse headers: %w", err)
 	}
 
-	// Add Idempotency key to Headers
-	headers["laile-idempotency-key"] = []string{event.IdempotencyKey}
-
-	// Add configured forwarder headers, overwriting any existing ones
-	for name, value := range f.Config.Headers {
-		headers[name] = []string{value}
+	// Add the target's idempotency key, unless a configured forwarder header replaces it
+	keyHeader := f.Config.IdempotencyKeyHeader
+	if event.IdempotencyKey != "" && !matchesHeader(slices.Collect(maps.Keys(f.Config.Headers)), keyHeader) {
+		http.Header(headers).Set(keyHeader, event.IdempotencyKey)
 	}
 
 	for name, headerValues := range headers {
@@ -67,13 +75,12 @@ func (f *HTTPForwarder) Forward(ctx context.Context, event *DeliveryAttempt) (*D
 	}
 
 	// Send the request to the target service
-	client := &http.Client{}
 	log.Logger.DebugContext(ctx, "Sending request",
 		"url", req.URL.String(),
 		"method", req.Method,
 		"header_count", len(req.Header))
 
-	resp, err := client.Do(req)
+	resp, err := f.client.Do(req)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "Request failed", slog.Any("error", err),
 			slog.String("url", req.URL.String()),
@@ -126,7 +133,37 @@ func getQueryParamsFromBytes(b []byte) (QueryParameters, error) {
 }
 
 func NewHTTPForwarder(config *config.Forwarder) *HTTPForwarder {
+	dialer := &net.Dialer{
+		Timeout:   seconds(config.Timeouts.Connect),
+		KeepAlive: keepAliveInterval,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   seconds(config.Timeouts.TLSHandshake),
+		ResponseHeaderTimeout: seconds(config.Timeouts.ResponseHeader),
+		// All requests go to the same host, so the whole pool is available to it
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConns,
+		IdleConnTimeout:       seconds(config.IdleTimeout),
+		ExpectContinueTimeout: 1 * time.Second,
+	}
 	return &HTTPForwarder{
 		Config: config,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   seconds(config.Timeouts.Total),
+		},
 	}
 }
+
+// Close closes the idle connections of the forwarder.
+func (f *HTTPForwarder) Close() error {
+	f.client.CloseIdleConnections()
+	return nil
+}
+
+func seconds(value int) time.Duration {
+	return time.Duration(value) * time.Second
+}
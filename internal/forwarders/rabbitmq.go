@@ -2,6 +2,7 @@ package forwarders
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +16,11 @@ import (
 	"laile/internal/log"
 )
 
+const (
+	amqpHeartbeat = 10 * time.Second
+	amqpLocale    = "en_US"
+)
+
 type RMQForwarder struct {
 	Session    *session
 	Config     *config.Forwarder
@@ -68,17 +74,16 @@ func (f *RMQForwarder) declareExchange() error {
 }
 
 func (f *RMQForwarder) Forward(ctx context.Context, deliveryAttempt *DeliveryAttempt) (*DeliveryResult, error) {
-	payload, err := webhookToAMQPBody(deliveryAttempt)
+	payload, err := webhookToAMQPBody(deliveryAttempt, f.Config)
 	if err != nil {
 		return nil, err
 	}
 
-	const forwardingTimeout = 5 * time.Second
-	timeoutContext, cancel := context.WithTimeout(ctx, forwardingTimeout)
+	timeoutContext, cancel := context.WithTimeout(ctx, seconds(f.Config.Timeouts.Total))
 	defer cancel()
 
 	// Single attempt to publish with proper error handling
-	err = f.publishToRMQ(timeoutContext, payload)
+	err = f.publishToRMQ(timeoutContext, payload, deliveryAttempt.IdempotencyKey)
 	if err != nil {
 		log.Logger.ErrorContext(ctx, "producer: error publishing message", "error", err)
 		return nil, err
@@ -94,9 +99,10 @@ func (f *RMQForwarder) Forward(ctx context.Context, deliveryAttempt *DeliveryAtt
 type publishConfig struct {
 	ExchangeName string
 	RoutingKey   string
+	MessageID    string // The target's idempotency key, so consumers can drop redelivered messages
 }
 
-func (f *RMQForwarder) publishToRMQ(ctx context.Context, payload message) error {
+func (f *RMQForwarder) publishToRMQ(ctx context.Context, payload message, messageID string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -108,6 +114,7 @@ func (f *RMQForwarder) publishToRMQ(ctx context.Context, payload message) error
 	err = rmqSession.publish(ctx, payload, &publishConfig{
 		ExchangeName: f.Config.Exchange,
 		RoutingKey:   f.Config.RoutingKey,
+		MessageID:    messageID,
 	})
 	if err != nil {
 		// Only dispose session on connection errors
@@ -121,20 +128,24 @@ func (f *RMQForwarder) publishToRMQ(ctx context.Context, payload message) error
 }
 
 type AMQPBody struct {
-	Headers        map[string][]string `json:"headers"`
-	Body           json.RawMessage     `json:"body,omitempty"`
-	QueryParams    map[string][]string `json:"query_params"`
-	Method         string              `json:"method"`
-	URL            string              `json:"url"`
-	IdempotencyKey string              `json:"idempotency_key"`
-	HelpText       string              `json:"help_text,omitempty"`
+	Headers         map[string][]string `json:"headers"`
+	Body            json.RawMessage     `json:"body,omitempty"`
+	BodyEncoding    string              `json:"body_encoding,omitempty"`
+	ContentType     string              `json:"content_type,omitempty"`
+	ContentEncoding string              `json:"content_encoding,omitempty"`
+	QueryParams     map[string][]string `json:"query_params"`
+	Method          string              `json:"method"`
+	URL             string              `json:"url"`
+	IdempotencyKey  string              `json:"idempotency_key"`
+	HelpText        string              `json:"help_text,omitempty"`
 }
 
 // webhookToAMQPBody marshals the DeliveryAttempt into a JSON byte slice.
-func webhookToAMQPBody(attempt *DeliveryAttempt) (message, error) {
-	var headers map[string][]string
-	if err := json.Unmarshal(attempt.Headers, &headers); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal headers: %w", err)
+// Headers follow the same policy as the HTTP forwarder.
+func webhookToAMQPBody(attempt *DeliveryAttempt, forwarder *config.Forwarder) (message, error) {
+	headers, err := OutgoingHeaders(attempt, forwarder)
+	if err != nil {
+		return nil, err
 	}
 
 	var queryParams map[string][]string
@@ -142,14 +153,23 @@ func webhookToAMQPBody(attempt *DeliveryAttempt) (message, error) {
 		return nil, fmt.Errorf("failed to unmarshal query params: %w", err)
 	}
 
+	body, bodyEncoding, err := EncodeEnvelopeBody(attempt)
+	if err != nil {
+		return nil, err
+	}
+
 	amqpBody := AMQPBody{
-		Headers:        headers,
-		Body:           *attempt.Body,
-		QueryParams:    queryParams,
-		Method:         attempt.Method,
-		URL:            attempt.URL,
-		IdempotencyKey: attempt.IdempotencyKey,
-		HelpText:       "body is a raw JSON message, headers and query parameters are key-value maps",
+		Headers:         headers,
+		Body:            body,
+		BodyEncoding:    bodyEncoding,
+		ContentType:     attempt.ContentType,
+		ContentEncoding: attempt.ContentEncoding,
+		QueryParams:     queryParams,
+		Method:          attempt.Method,
+		URL:             attempt.URL,
+		IdempotencyKey:  attempt.IdempotencyKey,
+		HelpText: "body is a raw JSON message, or a base64 string of the original bytes when body_encoding is base64. " +
+			"headers and query parameters are key-value maps",
 	}
 	resp, err := json.Marshal(amqpBody)
 	if err != nil {
@@ -159,6 +179,24 @@ func webhookToAMQPBody(attempt *DeliveryAttempt) (message, error) {
 	return resp, nil
 }
 
+// EncodeEnvelopeBody embeds JSON payloads as is and base64 encodes everything else,
+// since only valid JSON can be embedded in a JSON envelope.
+func EncodeEnvelopeBody(attempt *DeliveryAttempt) (json.RawMessage, string, error) {
+	if attempt.Body == nil || len(*attempt.Body) == 0 {
+		return nil, "", nil
+	}
+	body := *attempt.Body
+	if attempt.ContentEncoding == "" && json.Valid(body) {
+		return body, "", nil
+	}
+
+	encoded, err := json.Marshal(base64.StdEncoding.EncodeToString(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode envelope body: %w", err)
+	}
+	return encoded, "base64", nil
+}
+
 func (f *RMQForwarder) getSession() (*session, error) {
 	if f.Session != nil && !f.Session.IsClosed() {
 		return f.Session, nil
@@ -175,7 +213,12 @@ func (s *session) IsClosed() bool {
 }
 
 func (f *RMQForwarder) createSession() error {
-	conn, err := amqp091.Dial(f.Config.ConnectionURL)
+	// The same settings as amqp091.Dial, with the forwarder's connect timeout
+	conn, err := amqp091.DialConfig(f.Config.ConnectionURL, amqp091.Config{
+		Heartbeat: amqpHeartbeat,
+		Locale:    amqpLocale,
+		Dial:      amqp091.DefaultDial(seconds(f.Config.Timeouts.Connect)),
+	})
 	log.Logger.Info("dialing", "url", f.Config.ConnectionURL)
 	if err != nil {
 		log.Logger.Error("cannot dial RMQ event forwarder", "error", err, "url", f.Config.ConnectionURL)
@@ -197,6 +240,18 @@ func (f *RMQForwarder) createSession() error {
 	return nil
 }
 
+// Close closes the session of the forwarder, a later Forward opens a new one.
+func (f *RMQForwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Session == nil {
+		return nil
+	}
+	err := f.Session.Close()
+	f.Session = nil
+	return err
+}
+
 func (f *RMQForwarder) disposeSession() {
 	if f.Session != nil {
 		err := f.Session.Close()
@@ -209,11 +264,8 @@ func (f *RMQForwarder) disposeSession() {
 
 // publish publishes messages to a reconnecting session to a configured exchange.
 // It receives from the application specific source of messages.
-func (s *session) publish(parentCtx context.Context, payload message, cfg *publishConfig) error {
-	const publishTimeout = 5 * time.Second
-	ctx, cancel := context.WithTimeout(parentCtx, publishTimeout)
-	defer cancel()
-
+// The context bounds the publish and the wait for the broker's confirmation.
+func (s *session) publish(ctx context.Context, payload message, cfg *publishConfig) error {
 	confirm := make(chan amqp091.Confirmation, 1)
 	forwarderChannel := s.Channel
 
@@ -239,13 +291,13 @@ func (s *session) publish(parentCtx context.Context, payload message, cfg *publi
 			Priority:        0,
 			AppId:           "laile-webhook-forwarder",
 			Body:            payload,
+			MessageId:       cfg.MessageID,
 
 			// The following fields are not supported by the forwarder implementation,
 			// and will be populated with the zero values.
 			CorrelationId: "",
 			ReplyTo:       "",
 			Expiration:    "",
-			MessageId:     "",
 			Timestamp:     time.Time{},
 			Type:          "",
 			UserId:        "",
@@ -256,7 +308,13 @@ func (s *session) publish(parentCtx context.Context, payload message, cfg *publi
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	confirmed, ok := <-confirm
+	var confirmed amqp091.Confirmation
+	var ok bool
+	select {
+	case confirmed, ok = <-confirm:
+	case <-ctx.Done():
+		return fmt.Errorf("RMQ did not confirm the publish in time: %w", ctx.Err())
+	}
 	if !ok {
 		return errors.New("RMQ did not confirm the publish")
 	}
@@ -2,8 +2,6 @@ package forwarders
 
 import (
 	"context"
-	"fmt"
-	"time"
 
 	"laile/internal/config"
 	"laile/internal/log"
@@ -11,27 +9,33 @@ import (
 )
 
 type DeliveryAttempt struct {
-	Headers        []byte
-	Body           *[]byte
-	QueryParams    []byte
-	Method         string
-	URL            string
-	IdempotencyKey string
+	Headers []byte
+	// Body holds the payload exactly as it was received
+	Body            *[]byte
+	ContentType     string
+	ContentEncoding string
+	QueryParams     []byte
+	Method          string
+	URL             string
+	IdempotencyKey  string
+	// ExtraHeaders are added after the forwarder's header policy is applied, e.g. by transforms
+	ExtraHeaders map[string]string
 }
 
-func NewDeliveryAttempt(event db_models.GetDueDeliveryAttemptsRow, forwarder *config.Forwarder) *DeliveryAttempt {
+func NewDeliveryAttempt(event db_models.GetClaimedDeliveryAttemptRow, forwarder *config.Forwarder) *DeliveryAttempt {
 	log.Logger.DebugContext(context.Background(), "Creating new delivery attempt",
 		"event_id", event.ID,
 		"body_length", len(event.Body))
 
-	bodyBytes := []byte(event.Body)
 	deliveryAttempt := &DeliveryAttempt{
-		Headers:        event.Headers,
-		Body:           &bodyBytes,
-		QueryParams:    event.QueryParams,
-		Method:         event.Method,
-		URL:            forwarder.URL,
-		IdempotencyKey: fmt.Sprintf("%s-%d-%d", event.ForwarderID, time.Now().Unix(), event.ID),
+		Headers:         event.Headers,
+		Body:            &event.Body,
+		ContentType:     event.ContentType.String,
+		ContentEncoding: event.ContentEncoding.String,
+		QueryParams:     event.QueryParams,
+		Method:          event.Method,
+		URL:             forwarder.URL,
+		IdempotencyKey:  event.TargetIdempotencyKey.String,
 	}
 	return deliveryAttempt
 }
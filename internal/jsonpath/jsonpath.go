@@ -0,0 +1,136 @@
+// Package jsonpath looks up values in JSON documents with a small subset of JSONPath:
+// "$", ".field", "['field']" and "[index]" steps, e.g. "$.data.object.id" or "$.items[0]['name']".
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPath is returned when a path can't be parsed.
+var ErrInvalidPath = errors.New("invalid json path")
+
+// step is a single object key or array index in a path.
+type step struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// Path is a parsed JSON path.
+type Path struct {
+	raw   string
+	steps []step
+}
+
+// Parse parses a path starting with "$".
+func Parse(path string) (*Path, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q must start with $", ErrInvalidPath, path)
+	}
+
+	parsed := &Path{raw: path}
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("%w: %q has an empty field name", ErrInvalidPath, path)
+			}
+			parsed.steps = append(parsed.steps, step{key: rest[:end], isKey: true})
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end == -1 {
+				return nil, fmt.Errorf("%w: %q has an unterminated ['...']", ErrInvalidPath, path)
+			}
+			parsed.steps = append(parsed.steps, step{key: rest[2:end], isKey: true})
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("%w: %q has an unterminated [...]", ErrInvalidPath, path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%w: %q has an invalid array index %q", ErrInvalidPath, path, rest[1:end])
+			}
+			parsed.steps = append(parsed.steps, step{index: index})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q in %q", ErrInvalidPath, rest, path)
+		}
+	}
+	return parsed, nil
+}
+
+// String returns the path as it was written.
+func (p *Path) String() string {
+	return p.raw
+}
+
+// Lookup returns the value at the path in a document decoded with Decode.
+func (p *Path) Lookup(document any) (any, bool) {
+	current := document
+	for _, s := range p.steps {
+		if s.isKey {
+			object, ok := current.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			current, ok = object[s.key]
+			if !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		array, ok := current.([]any)
+		if !ok || s.index >= len(array) {
+			return nil, false
+		}
+		current = array[s.index]
+	}
+	return current, true
+}
+
+// Decode reads a JSON document, keeping numbers as json.Number so they compare and print exactly.
+func Decode(r io.Reader) (any, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode json document: %w", err)
+	}
+	return document, nil
+}
+
+// Format returns the text form of a value: strings and numbers as they are, null as "",
+// and objects and arrays as compact JSON.
+func Format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	}
+}
@@ -0,0 +1,122 @@
+package jsonpath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const document = `{
+	"type": "invoice.paid",
+	"data": {"object": {"id": "in_123", "amount": 1050, "paid": true, "note": null}},
+	"items": [{"name": "first"}, {"name": "second", "tags": ["a", "b"]}],
+	"odd.key": "dotted",
+	"list": [[1, 2], [3]]
+}`
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "empty", path: ""},
+		{name: "no root", path: "data.object"},
+		{name: "root not first", path: "data.$"},
+		{name: "trailing dot", path: "$.data."},
+		{name: "double dot", path: "$..data"},
+		{name: "dot before bracket", path: "$.[0]"},
+		{name: "unterminated quoted key", path: "$['data"},
+		{name: "unterminated index", path: "$.items[0"},
+		{name: "negative index", path: "$.items[-1]"},
+		{name: "non numeric index", path: "$.items[first]"},
+		{name: "empty index", path: "$.items[]"},
+		{name: "wildcard", path: "$.items[*]"},
+		{name: "text after root", path: "$data"},
+		{name: "text after index", path: "$.items[0]name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(tt.path)
+			if !errors.Is(err, ErrInvalidPath) {
+				t.Fatalf("Parse(%q) = %v, %v, want ErrInvalidPath", tt.path, parsed, err)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	decoded, err := Decode(strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		want  string
+		found bool
+	}{
+		{name: "root", path: "$", want: "", found: true},
+		{name: "dot field", path: "$.type", want: "invoice.paid", found: true},
+		{name: "nested dot fields", path: "$.data.object.id", want: "in_123", found: true},
+		{name: "bracket field", path: "$['data']['object']['id']", want: "in_123", found: true},
+		{name: "mixed dot and bracket", path: "$.data['object'].id", want: "in_123", found: true},
+		{name: "bracket field with dot", path: "$['odd.key']", want: "dotted", found: true},
+		{name: "number keeps its text", path: "$.data.object.amount", want: "1050", found: true},
+		{name: "bool", path: "$.data.object.paid", want: "true", found: true},
+		{name: "null", path: "$.data.object.note", want: "", found: true},
+		{name: "object as json", path: "$.items[0]", want: `{"name":"first"}`, found: true},
+		{name: "array index", path: "$.items[1].name", want: "second", found: true},
+		{name: "array index then bracket field", path: "$.items[0]['name']", want: "first", found: true},
+		{name: "nested array index", path: "$.items[1].tags[1]", want: "b", found: true},
+		{name: "index of index", path: "$.list[0][1]", want: "2", found: true},
+		{name: "missing field", path: "$.data.missing", found: false},
+		{name: "index out of range", path: "$.items[2]", found: false},
+		{name: "index on object", path: "$.data[0]", found: false},
+		{name: "field on array", path: "$.items.name", found: false},
+		{name: "field on string", path: "$.type.name", found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(tt.path)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.path, err)
+			}
+			if parsed.String() != tt.path {
+				t.Errorf("String() = %q, want %q", parsed.String(), tt.path)
+			}
+			value, found := parsed.Lookup(decoded)
+			if found != tt.found {
+				t.Fatalf("Lookup(%q) found = %v, want %v", tt.path, found, tt.found)
+			}
+			if !tt.found {
+				return
+			}
+			// The root is the whole document, only check that it was found
+			if tt.path == "$" {
+				return
+			}
+			if got := Format(value); got != tt.want {
+				t.Errorf("Lookup(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupOnNonJSON(t *testing.T) {
+	parsed, err := Parse("$.type")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if value, found := parsed.Lookup(nil); found {
+		t.Errorf("Lookup on a nil document = %v, want not found", value)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, body := range []string{"", "{", "not json", `{"a": }`} {
+		if _, err := Decode(strings.NewReader(body)); err == nil {
+			t.Errorf("Decode(%q) succeeded, want an error", body)
+		}
+	}
+}
@@ -11,6 +11,49 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type CircuitState string
+
+const (
+	CircuitStateClosed   CircuitState = "closed"
+	CircuitStateOpen     CircuitState = "open"
+	CircuitStateHalfOpen CircuitState = "half_open"
+)
+
+func (e *CircuitState) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CircuitState(s)
+	case string:
+		*e = CircuitState(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CircuitState: %T", src)
+	}
+	return nil
+}
+
+type NullCircuitState struct {
+	CircuitState CircuitState
+	Valid        bool // Valid is true if CircuitState is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCircuitState) Scan(value interface{}) error {
+	if value == nil {
+		ns.CircuitState, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CircuitState.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCircuitState) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CircuitState), nil
+}
+
 type DeliveryStatus string
 
 const (
@@ -20,6 +63,7 @@ const (
 	DeliveryStatusSuccess    DeliveryStatus = "success"
 	DeliveryStatusFailed     DeliveryStatus = "failed"
 	DeliveryStatusNotNeeded  DeliveryStatus = "not_needed"
+	DeliveryStatusDeadLetter DeliveryStatus = "dead_letter"
 )
 
 func (e *DeliveryStatus) Scan(src interface{}) error {
@@ -57,6 +101,20 @@ func (ns NullDeliveryStatus) Value() (driver.Value, error) {
 	return string(ns.DeliveryStatus), nil
 }
 
+type CircuitBreaker struct {
+	WebhookServiceID    string
+	ForwarderID         string
+	State               CircuitState
+	ConsecutiveFailures int32
+	WindowStartedAt     pgtype.Timestamptz
+	WindowTotal         int32
+	WindowFailures      int32
+	Probes              int32
+	OpenedAt            pgtype.Timestamptz
+	RetryAt             pgtype.Timestamptz
+	UpdatedAt           pgtype.Timestamptz
+}
+
 type DeliveryAttempt struct {
 	ID              int64
 	TargetID        pgtype.Int8
@@ -70,13 +128,30 @@ type DeliveryAttempt struct {
 	CreatedAt       pgtype.Timestamptz
 	HashValue       int64
 	WorkerName      pgtype.Text
+	RequestBody     []byte
+	RequestHeaders  []byte
 }
 
 type HashRing struct {
-	ID        int32
-	NodeName  string
-	VirtualID int32
-	HashKey   int64
+	ID          int32
+	NodeName    string
+	VirtualID   int32
+	HashKey     int64
+	HeartbeatAt pgtype.Timestamptz
+}
+
+type RateLimitBucket struct {
+	WebhookServiceID string
+	ForwarderID      string
+	Tokens           float64
+	RefilledAt       pgtype.Timestamptz
+}
+
+type SignatureReplayCache struct {
+	WebhookServiceID string
+	ReplayKey        string
+	ExpiresAt        pgtype.Timestamptz
+	CreatedAt        pgtype.Timestamptz
 }
 
 type TaskLock struct {
@@ -92,19 +167,28 @@ type Webhook struct {
 	Name             string
 	Url              string
 	Method           string
-	Body             string
+	Body             []byte
 	Headers          []byte
 	QueryParams      []byte
 	WebhookServiceID string
 	DeliveryStatus   DeliveryStatus
 	CreatedAt        pgtype.Timestamptz
 	IdempotencyKey   pgtype.Text
+	VerifiedSecret   pgtype.Text
+	ContentType      pgtype.Text
+	ContentEncoding  pgtype.Text
+	BodyOid          pgtype.Int8
+	DedupKey         pgtype.Text
+	EventType        pgtype.Text
 }
 
 type WebhookTarget struct {
-	ID          int64
-	WebhookID   pgtype.Int8
-	ForwarderID string
-	CreatedAt   pgtype.Timestamptz
-	HashValue   int64
+	ID             int64
+	WebhookID      pgtype.Int8
+	ForwarderID    string
+	CreatedAt      pgtype.Timestamptz
+	HashValue      int64
+	Status         DeliveryStatus
+	OrderingKey    pgtype.Text
+	IdempotencyKey pgtype.Text
 }
@@ -11,20 +11,60 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const acquireCircuitBreakerProbe = `-- name: AcquireCircuitBreakerProbe :execrows
+UPDATE circuit_breakers SET
+    state = 'half_open',
+    probes = CASE WHEN state = 'open' OR retry_at <= NOW() THEN 1 ELSE probes + 1 END,
+    retry_at = CASE WHEN state = 'open' OR retry_at <= NOW()
+        THEN NOW() + $1::integer * INTERVAL '1 second' ELSE retry_at END,
+    updated_at = NOW()
+WHERE webhook_service_id = $2 AND forwarder_id = $3
+  AND state <> 'closed'
+  AND (retry_at <= NOW() OR (state = 'half_open' AND probes < $4::integer))
+`
+
+type AcquireCircuitBreakerProbeParams struct {
+	OpenDuration     int32
+	WebhookServiceID string
+	ForwarderID      string
+	MaxProbes        int32
+}
+
+// Lets a probe delivery through once the retry time of the breaker passed. A half-open breaker
+// hands out up to max_probes probes, and starts over when they didn't report back within the open
+// duration, in seconds.
+func (q *Queries) AcquireCircuitBreakerProbe(ctx context.Context, arg AcquireCircuitBreakerProbeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, acquireCircuitBreakerProbe,
+		arg.OpenDuration,
+		arg.WebhookServiceID,
+		arg.ForwarderID,
+		arg.MaxProbes,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const acquireTaskLock = `-- name: AcquireTaskLock :one
-INSERT INTO task_locks (task_name, worker_name, acquired_at)
-VALUES ($1, $2, NOW())
-ON CONFLICT (task_name) DO NOTHING
+INSERT INTO task_locks (task_name, worker_name, acquired_at, touched_at)
+VALUES ($1, $2, NOW(), NOW())
+ON CONFLICT (task_name) DO UPDATE
+    SET worker_name = EXCLUDED.worker_name, acquired_at = NOW(), touched_at = NOW()
+    WHERE task_locks.touched_at < NOW() - $3::integer * INTERVAL '1 second'
 RETURNING id, task_name, worker_name, acquired_at, touched_at
 `
 
 type AcquireTaskLockParams struct {
 	TaskName   string
 	WorkerName string
+	Lease      int32
 }
 
+// Takes the lock when it's free or when its holder didn't touch it within the lease, in seconds.
+// No row is returned while another worker holds the lock.
 func (q *Queries) AcquireTaskLock(ctx context.Context, arg AcquireTaskLockParams) (TaskLock, error) {
-	row := q.db.QueryRow(ctx, acquireTaskLock, arg.TaskName, arg.WorkerName)
+	row := q.db.QueryRow(ctx, acquireTaskLock, arg.TaskName, arg.WorkerName, arg.Lease)
 	var i TaskLock
 	err := row.Scan(
 		&i.ID,
@@ -42,24 +82,49 @@ SET status = 'processing', worker_name = $1, executed_at = NOW()
 WHERE id = (
     SELECT id FROM delivery_attempts
     WHERE status = 'scheduled'
-      AND scheduled_for <= NOW()
+      AND (scheduled_for <= NOW() OR scheduled_for IS NULL)
       AND delivery_attempts.hash_value >= $2
       AND delivery_attempts.hash_value < $3
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND w.webhook_service_id || '-' || wt.forwarder_id = ANY($4::text[])
+      )
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+            JOIN webhook_targets earlier ON earlier.forwarder_id = wt.forwarder_id
+                AND earlier.ordering_key = wt.ordering_key
+                AND earlier.id < wt.id
+            JOIN webhooks ew ON earlier.webhook_id = ew.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND earlier.status = 'scheduled'
+          AND ew.webhook_service_id = w.webhook_service_id
+      )
     ORDER BY scheduled_for, hash_value
         FOR UPDATE SKIP LOCKED
     LIMIT 1
 )
-RETURNING id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, created_at, hash_value, worker_name
+RETURNING id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, created_at, hash_value, worker_name, request_body, request_headers
 `
 
 type ClaimDeliveryAttemptParams struct {
-	WorkerName pgtype.Text
-	HashStart  int64
-	HashEnd    int64
+	WorkerName     pgtype.Text
+	HashStart      int64
+	HashEnd        int64
+	BusyForwarders []string
 }
 
+// Attempts for forwarders that are at their max_in_flight stay scheduled for the next round, and so
+// do attempts whose target waits for an earlier target with the same ordering key.
 func (q *Queries) ClaimDeliveryAttempt(ctx context.Context, arg ClaimDeliveryAttemptParams) (DeliveryAttempt, error) {
-	row := q.db.QueryRow(ctx, claimDeliveryAttempt, arg.WorkerName, arg.HashStart, arg.HashEnd)
+	row := q.db.QueryRow(ctx, claimDeliveryAttempt,
+		arg.WorkerName,
+		arg.HashStart,
+		arg.HashEnd,
+		arg.BusyForwarders,
+	)
 	var i DeliveryAttempt
 	err := row.Scan(
 		&i.ID,
@@ -74,6 +139,8 @@ func (q *Queries) ClaimDeliveryAttempt(ctx context.Context, arg ClaimDeliveryAtt
 		&i.CreatedAt,
 		&i.HashValue,
 		&i.WorkerName,
+		&i.RequestBody,
+		&i.RequestHeaders,
 	)
 	return i, err
 }
@@ -84,25 +151,49 @@ SET status = 'processing', worker_name = $1, executed_at = NOW()
 WHERE id = (
     SELECT id FROM delivery_attempts
     WHERE status = 'scheduled'
-      AND scheduled_for <= NOW()
-      AND delivery_attempts.hash_value >= $2
-      OR delivery_attempts.hash_value < $2
+      AND (scheduled_for <= NOW() OR scheduled_for IS NULL)
+      AND (delivery_attempts.hash_value >= $2
+        OR delivery_attempts.hash_value < $3)
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND w.webhook_service_id || '-' || wt.forwarder_id = ANY($4::text[])
+      )
+      AND NOT EXISTS (
+        SELECT 1 FROM webhook_targets wt
+            JOIN webhooks w ON wt.webhook_id = w.id
+            JOIN webhook_targets earlier ON earlier.forwarder_id = wt.forwarder_id
+                AND earlier.ordering_key = wt.ordering_key
+                AND earlier.id < wt.id
+            JOIN webhooks ew ON earlier.webhook_id = ew.id
+        WHERE wt.id = delivery_attempts.target_id
+          AND earlier.status = 'scheduled'
+          AND ew.webhook_service_id = w.webhook_service_id
+      )
     ORDER BY scheduled_for, hash_value
         FOR UPDATE SKIP LOCKED
     LIMIT 1
 )
-RETURNING id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, created_at, hash_value, worker_name
+RETURNING id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, created_at, hash_value, worker_name, request_body, request_headers
 `
 
 type ClaimDeliveryAttemptFromEndParams struct {
-	WorkerName pgtype.Text
-	HashEnd    int64
+	WorkerName     pgtype.Text
+	HashStart      int64
+	HashEnd        int64
+	BusyForwarders []string
 }
 
 // If a node is in charge of the end of the hash ring, it needs to go back and claim the tasks
 // from the start of the hash ring.
 func (q *Queries) ClaimDeliveryAttemptFromEnd(ctx context.Context, arg ClaimDeliveryAttemptFromEndParams) (DeliveryAttempt, error) {
-	row := q.db.QueryRow(ctx, claimDeliveryAttemptFromEnd, arg.WorkerName, arg.HashEnd)
+	row := q.db.QueryRow(ctx, claimDeliveryAttemptFromEnd,
+		arg.WorkerName,
+		arg.HashStart,
+		arg.HashEnd,
+		arg.BusyForwarders,
+	)
 	var i DeliveryAttempt
 	err := row.Scan(
 		&i.ID,
@@ -117,10 +208,32 @@ func (q *Queries) ClaimDeliveryAttemptFromEnd(ctx context.Context, arg ClaimDeli
 		&i.CreatedAt,
 		&i.HashValue,
 		&i.WorkerName,
+		&i.RequestBody,
+		&i.RequestHeaders,
 	)
 	return i, err
 }
 
+const closeCircuitBreaker = `-- name: CloseCircuitBreaker :execrows
+UPDATE circuit_breakers SET
+    state = 'closed', consecutive_failures = 0, probes = 0, opened_at = NULL, retry_at = NULL,
+    window_started_at = NOW(), window_total = 0, window_failures = 0, updated_at = NOW()
+WHERE webhook_service_id = $1 AND forwarder_id = $2 AND state = 'half_open'
+`
+
+type CloseCircuitBreakerParams struct {
+	WebhookServiceID string
+	ForwarderID      string
+}
+
+func (q *Queries) CloseCircuitBreaker(ctx context.Context, arg CloseCircuitBreakerParams) (int64, error) {
+	result, err := q.db.Exec(ctx, closeCircuitBreaker, arg.WebhookServiceID, arg.ForwarderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const countDueDeliveryAttempts = `-- name: CountDueDeliveryAttempts :one
 SELECT count(*) FROM delivery_attempts ds
 WHERE ds.status = 'scheduled' AND (ds.scheduled_for <= $1 OR ds.scheduled_for IS NULL)
@@ -133,6 +246,307 @@ func (q *Queries) CountDueDeliveryAttempts(ctx context.Context, scheduledFor pgt
 	return count, err
 }
 
+const delayDeliveryAttempt = `-- name: DelayDeliveryAttempt :exec
+UPDATE delivery_attempts
+SET status = 'scheduled', worker_name = NULL, executed_at = NULL,
+    scheduled_for = NOW() + $1::double precision * INTERVAL '1 second'
+WHERE id = $2 AND status = 'processing'
+`
+
+type DelayDeliveryAttemptParams struct {
+	Delay float64
+	ID    int64
+}
+
+// Puts a claimed attempt back to be delivered after a delay, in seconds. Like a released attempt it
+// doesn't count against the retries.
+func (q *Queries) DelayDeliveryAttempt(ctx context.Context, arg DelayDeliveryAttemptParams) error {
+	_, err := q.db.Exec(ctx, delayDeliveryAttempt, arg.Delay, arg.ID)
+	return err
+}
+
+const deleteExpiredSignatures = `-- name: DeleteExpiredSignatures :execrows
+DELETE FROM signature_replay_cache WHERE expires_at < NOW()
+`
+
+func (q *Queries) DeleteExpiredSignatures(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSignatures)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredWebhooks = `-- name: DeleteExpiredWebhooks :many
+DELETE FROM webhooks
+WHERE id IN (
+    SELECT id FROM webhooks
+    WHERE created_at < NOW() - $1::integer * INTERVAL '1 day'
+      AND delivery_status IN ('success', 'failed', 'not_needed')
+      AND NOT EXISTS (
+          SELECT 1 FROM webhook_targets t
+          WHERE t.webhook_id = webhooks.id AND t.status IN ('scheduled', 'processing')
+      )
+    ORDER BY id
+    LIMIT $2
+)
+RETURNING body_oid
+`
+
+type DeleteExpiredWebhooksParams struct {
+	RetentionDays int32
+	BatchSize     int32
+}
+
+// Deletes a batch of delivered events older than the retention period, in days. The event status
+// follows a single target, so events with targets that are still delivering are kept. The bodies
+// that were stored as large objects have to be unlinked by the caller.
+func (q *Queries) DeleteExpiredWebhooks(ctx context.Context, arg DeleteExpiredWebhooksParams) ([]pgtype.Int8, error) {
+	rows, err := q.db.Query(ctx, deleteExpiredWebhooks, arg.RetentionDays, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []pgtype.Int8
+	for rows.Next() {
+		var body_oid pgtype.Int8
+		if err := rows.Scan(&body_oid); err != nil {
+			return nil, err
+		}
+		items = append(items, body_oid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const expireHashRingNodes = `-- name: ExpireHashRingNodes :execrows
+DELETE FROM hash_ring WHERE heartbeat_at < NOW() - $1::integer * INTERVAL '1 second'
+`
+
+// The timeout is in seconds, compared with the database clock like the heartbeats.
+func (q *Queries) ExpireHashRingNodes(ctx context.Context, timeout int32) (int64, error) {
+	result, err := q.db.Exec(ctx, expireHashRingNodes, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getCircuitBreakers = `-- name: GetCircuitBreakers :many
+SELECT webhook_service_id, forwarder_id, state, consecutive_failures, window_started_at, window_total, window_failures, probes, opened_at, retry_at, updated_at FROM circuit_breakers
+ORDER BY state = 'closed', webhook_service_id, forwarder_id
+`
+
+func (q *Queries) GetCircuitBreakers(ctx context.Context) ([]CircuitBreaker, error) {
+	rows, err := q.db.Query(ctx, getCircuitBreakers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CircuitBreaker
+	for rows.Next() {
+		var i CircuitBreaker
+		if err := rows.Scan(
+			&i.WebhookServiceID,
+			&i.ForwarderID,
+			&i.State,
+			&i.ConsecutiveFailures,
+			&i.WindowStartedAt,
+			&i.WindowTotal,
+			&i.WindowFailures,
+			&i.Probes,
+			&i.OpenedAt,
+			&i.RetryAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getClaimedDeliveryAttempt = `-- name: GetClaimedDeliveryAttempt :one
+SELECT da.id, da.target_id, da.status, da.scheduled_for, da.executed_at, da.response_code, da.response_body, da.response_headers, da.error_message, da.created_at, da.hash_value, da.worker_name, da.request_body, da.request_headers,
+    wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value, wt.status, wt.ordering_key,
+    wt.idempotency_key AS target_idempotency_key,
+    w.id AS event_id, w.name, w.url, w.method, w.body, w.headers, w.query_params, w.webhook_service_id,
+    w.delivery_status, w.created_at AS received_at, w.idempotency_key AS event_idempotency_key,
+    w.verified_secret, w.content_type, w.content_encoding, w.body_oid, w.dedup_key, w.event_type
+FROM delivery_attempts da
+    JOIN public.webhook_targets wt on da.target_id = wt.id
+    JOIN public.webhooks w on wt.webhook_id = w.id
+WHERE da.id = $1
+`
+
+type GetClaimedDeliveryAttemptRow struct {
+	ID                   int64
+	TargetID             pgtype.Int8
+	Status               DeliveryStatus
+	ScheduledFor         pgtype.Timestamptz
+	ExecutedAt           pgtype.Timestamptz
+	ResponseCode         pgtype.Int4
+	ResponseBody         pgtype.Text
+	ResponseHeaders      []byte
+	ErrorMessage         pgtype.Text
+	CreatedAt            pgtype.Timestamptz
+	HashValue            int64
+	WorkerName           pgtype.Text
+	RequestBody          []byte
+	RequestHeaders       []byte
+	ID_2                 int64
+	WebhookID            pgtype.Int8
+	ForwarderID          string
+	CreatedAt_2          pgtype.Timestamptz
+	HashValue_2          int64
+	Status_2             DeliveryStatus
+	OrderingKey          pgtype.Text
+	TargetIdempotencyKey pgtype.Text
+	EventID              int64
+	Name                 string
+	Url                  string
+	Method               string
+	Body                 []byte
+	Headers              []byte
+	QueryParams          []byte
+	WebhookServiceID     string
+	DeliveryStatus       DeliveryStatus
+	ReceivedAt           pgtype.Timestamptz
+	EventIdempotencyKey  pgtype.Text
+	VerifiedSecret       pgtype.Text
+	ContentType          pgtype.Text
+	ContentEncoding      pgtype.Text
+	BodyOid              pgtype.Int8
+	DedupKey             pgtype.Text
+	EventType            pgtype.Text
+}
+
+func (q *Queries) GetClaimedDeliveryAttempt(ctx context.Context, id int64) (GetClaimedDeliveryAttemptRow, error) {
+	row := q.db.QueryRow(ctx, getClaimedDeliveryAttempt, id)
+	var i GetClaimedDeliveryAttemptRow
+	err := row.Scan(
+		&i.ID,
+		&i.TargetID,
+		&i.Status,
+		&i.ScheduledFor,
+		&i.ExecutedAt,
+		&i.ResponseCode,
+		&i.ResponseBody,
+		&i.ResponseHeaders,
+		&i.ErrorMessage,
+		&i.CreatedAt,
+		&i.HashValue,
+		&i.WorkerName,
+		&i.RequestBody,
+		&i.RequestHeaders,
+		&i.ID_2,
+		&i.WebhookID,
+		&i.ForwarderID,
+		&i.CreatedAt_2,
+		&i.HashValue_2,
+		&i.Status_2,
+		&i.OrderingKey,
+		&i.TargetIdempotencyKey,
+		&i.EventID,
+		&i.Name,
+		&i.Url,
+		&i.Method,
+		&i.Body,
+		&i.Headers,
+		&i.QueryParams,
+		&i.WebhookServiceID,
+		&i.DeliveryStatus,
+		&i.ReceivedAt,
+		&i.EventIdempotencyKey,
+		&i.VerifiedSecret,
+		&i.ContentType,
+		&i.ContentEncoding,
+		&i.BodyOid,
+		&i.DedupKey,
+		&i.EventType,
+	)
+	return i, err
+}
+
+const getDeadLetterTargets = `-- name: GetDeadLetterTargets :many
+SELECT
+    wt.id,
+    wt.forwarder_id,
+    wt.created_at,
+    w.webhook_service_id,
+    w.event_type,
+    la.error_message,
+    la.executed_at,
+    (SELECT count(*) FROM delivery_attempts da WHERE da.target_id = wt.id) AS attempt_count
+FROM webhook_targets wt
+         JOIN webhooks w ON wt.webhook_id = w.id
+         LEFT JOIN LATERAL (
+    SELECT error_message, executed_at
+    FROM delivery_attempts
+    WHERE target_id = wt.id
+    ORDER BY created_at DESC
+    LIMIT 1
+    ) la ON true
+WHERE
+    wt.status = 'dead_letter' AND
+    ($1::text = '' OR w.webhook_service_id = $1) AND
+    ($2::bigint = 0 OR wt.id < $2)
+ORDER BY wt.id DESC
+LIMIT $3
+`
+
+type GetDeadLetterTargetsParams struct {
+	ServiceID string
+	Cursor    int64
+	PageSize  int32
+}
+
+type GetDeadLetterTargetsRow struct {
+	ID               int64
+	ForwarderID      string
+	CreatedAt        pgtype.Timestamptz
+	WebhookServiceID string
+	EventType        pgtype.Text
+	ErrorMessage     pgtype.Text
+	ExecutedAt       pgtype.Timestamptz
+	AttemptCount     int64
+}
+
+// Lists targets that ran out of retries, with the error of their last attempt.
+func (q *Queries) GetDeadLetterTargets(ctx context.Context, arg GetDeadLetterTargetsParams) ([]GetDeadLetterTargetsRow, error) {
+	rows, err := q.db.Query(ctx, getDeadLetterTargets, arg.ServiceID, arg.Cursor, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDeadLetterTargetsRow
+	for rows.Next() {
+		var i GetDeadLetterTargetsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ForwarderID,
+			&i.CreatedAt,
+			&i.WebhookServiceID,
+			&i.EventType,
+			&i.ErrorMessage,
+			&i.ExecutedAt,
+			&i.AttemptCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getDeliveryAttemptCount = `-- name: GetDeliveryAttemptCount :one
 SELECT count(*) FROM delivery_attempts
 WHERE target_id = $1
@@ -152,19 +566,27 @@ SELECT
     executed_at,
     created_at,
     response_body,
-    status
+    response_code,
+    error_message,
+    status,
+    request_body,
+    request_headers
 FROM delivery_attempts
 WHERE target_id = $1
 ORDER BY created_at DESC
 `
 
 type GetDeliveryAttemptsByTargetIdRow struct {
-	ID           int64
-	ScheduledFor pgtype.Timestamptz
-	ExecutedAt   pgtype.Timestamptz
-	CreatedAt    pgtype.Timestamptz
-	ResponseBody pgtype.Text
-	Status       DeliveryStatus
+	ID             int64
+	ScheduledFor   pgtype.Timestamptz
+	ExecutedAt     pgtype.Timestamptz
+	CreatedAt      pgtype.Timestamptz
+	ResponseBody   pgtype.Text
+	ResponseCode   pgtype.Int4
+	ErrorMessage   pgtype.Text
+	Status         DeliveryStatus
+	RequestBody    []byte
+	RequestHeaders []byte
 }
 
 func (q *Queries) GetDeliveryAttemptsByTargetId(ctx context.Context, targetID pgtype.Int8) ([]GetDeliveryAttemptsByTargetIdRow, error) {
@@ -182,7 +604,11 @@ func (q *Queries) GetDeliveryAttemptsByTargetId(ctx context.Context, targetID pg
 			&i.ExecutedAt,
 			&i.CreatedAt,
 			&i.ResponseBody,
+			&i.ResponseCode,
+			&i.ErrorMessage,
 			&i.Status,
+			&i.RequestBody,
+			&i.RequestHeaders,
 		); err != nil {
 			return nil, err
 		}
@@ -195,7 +621,7 @@ func (q *Queries) GetDeliveryAttemptsByTargetId(ctx context.Context, targetID pg
 }
 
 const getDeliveryAttemptsList = `-- name: GetDeliveryAttemptsList :many
-SELECT da.id, da.target_id, da.status, da.scheduled_for, da.executed_at, da.response_code, da.response_body, da.response_headers, da.error_message, da.created_at, da.hash_value, da.worker_name, wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value, w.id, w.name, w.url, w.method, w.body, w.headers, w.query_params, w.webhook_service_id, w.delivery_status, w.created_at, w.idempotency_key
+SELECT da.id, da.target_id, da.status, da.scheduled_for, da.executed_at, da.response_code, da.response_body, da.response_headers, da.error_message, da.created_at, da.hash_value, da.worker_name, da.request_body, da.request_headers, wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value, wt.status, wt.ordering_key, wt.idempotency_key, w.id, w.name, w.url, w.method, w.body, w.headers, w.query_params, w.webhook_service_id, w.delivery_status, w.created_at, w.idempotency_key, w.verified_secret, w.content_type, w.content_encoding, w.body_oid, w.dedup_key, w.event_type
 FROM delivery_attempts da
          JOIN webhook_targets wt ON da.target_id = wt.id
          JOIN webhooks w ON wt.webhook_id = w.id
@@ -229,22 +655,33 @@ type GetDeliveryAttemptsListRow struct {
 	CreatedAt        pgtype.Timestamptz
 	HashValue        int64
 	WorkerName       pgtype.Text
+	RequestBody      []byte
+	RequestHeaders   []byte
 	ID_2             int64
 	WebhookID        pgtype.Int8
 	ForwarderID      string
 	CreatedAt_2      pgtype.Timestamptz
 	HashValue_2      int64
+	Status_2         DeliveryStatus
+	OrderingKey      pgtype.Text
+	IdempotencyKey   pgtype.Text
 	ID_3             int64
 	Name             string
 	Url              string
 	Method           string
-	Body             string
+	Body             []byte
 	Headers          []byte
 	QueryParams      []byte
 	WebhookServiceID string
 	DeliveryStatus   DeliveryStatus
 	CreatedAt_3      pgtype.Timestamptz
-	IdempotencyKey   pgtype.Text
+	IdempotencyKey_2 pgtype.Text
+	VerifiedSecret   pgtype.Text
+	ContentType      pgtype.Text
+	ContentEncoding  pgtype.Text
+	BodyOid          pgtype.Int8
+	DedupKey         pgtype.Text
+	EventType        pgtype.Text
 }
 
 func (q *Queries) GetDeliveryAttemptsList(ctx context.Context, arg GetDeliveryAttemptsListParams) ([]GetDeliveryAttemptsListRow, error) {
@@ -275,98 +712,16 @@ func (q *Queries) GetDeliveryAttemptsList(ctx context.Context, arg GetDeliveryAt
 			&i.CreatedAt,
 			&i.HashValue,
 			&i.WorkerName,
+			&i.RequestBody,
+			&i.RequestHeaders,
 			&i.ID_2,
 			&i.WebhookID,
 			&i.ForwarderID,
 			&i.CreatedAt_2,
 			&i.HashValue_2,
-			&i.ID_3,
-			&i.Name,
-			&i.Url,
-			&i.Method,
-			&i.Body,
-			&i.Headers,
-			&i.QueryParams,
-			&i.WebhookServiceID,
-			&i.DeliveryStatus,
-			&i.CreatedAt_3,
+			&i.Status_2,
+			&i.OrderingKey,
 			&i.IdempotencyKey,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
-}
-
-const getDueDeliveryAttempts = `-- name: GetDueDeliveryAttempts :many
-SELECT da.id, da.target_id, da.status, da.scheduled_for, da.executed_at, da.response_code, da.response_body, da.response_headers, da.error_message, da.created_at, da.hash_value, da.worker_name, wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value, w.id, w.name, w.url, w.method, w.body, w.headers, w.query_params, w.webhook_service_id, w.delivery_status, w.created_at, w.idempotency_key FROM delivery_attempts da
-    JOIN public.webhook_targets wt on da.target_id = wt.id
-    JOIN public.webhooks w on wt.webhook_id = w.id
-WHERE da.status = 'scheduled' AND (da.scheduled_for <= $1 OR da.scheduled_for IS NULL)
-`
-
-type GetDueDeliveryAttemptsRow struct {
-	ID               int64
-	TargetID         pgtype.Int8
-	Status           DeliveryStatus
-	ScheduledFor     pgtype.Timestamptz
-	ExecutedAt       pgtype.Timestamptz
-	ResponseCode     pgtype.Int4
-	ResponseBody     pgtype.Text
-	ResponseHeaders  []byte
-	ErrorMessage     pgtype.Text
-	CreatedAt        pgtype.Timestamptz
-	HashValue        int64
-	WorkerName       pgtype.Text
-	ID_2             int64
-	WebhookID        pgtype.Int8
-	ForwarderID      string
-	CreatedAt_2      pgtype.Timestamptz
-	HashValue_2      int64
-	ID_3             int64
-	Name             string
-	Url              string
-	Method           string
-	Body             string
-	Headers          []byte
-	QueryParams      []byte
-	WebhookServiceID string
-	DeliveryStatus   DeliveryStatus
-	CreatedAt_3      pgtype.Timestamptz
-	IdempotencyKey   pgtype.Text
-}
-
-func (q *Queries) GetDueDeliveryAttempts(ctx context.Context, scheduledFor pgtype.Timestamptz) ([]GetDueDeliveryAttemptsRow, error) {
-	rows, err := q.db.Query(ctx, getDueDeliveryAttempts, scheduledFor)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetDueDeliveryAttemptsRow
-	for rows.Next() {
-		var i GetDueDeliveryAttemptsRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.TargetID,
-			&i.Status,
-			&i.ScheduledFor,
-			&i.ExecutedAt,
-			&i.ResponseCode,
-			&i.ResponseBody,
-			&i.ResponseHeaders,
-			&i.ErrorMessage,
-			&i.CreatedAt,
-			&i.HashValue,
-			&i.WorkerName,
-			&i.ID_2,
-			&i.WebhookID,
-			&i.ForwarderID,
-			&i.CreatedAt_2,
-			&i.HashValue_2,
 			&i.ID_3,
 			&i.Name,
 			&i.Url,
@@ -377,7 +732,13 @@ func (q *Queries) GetDueDeliveryAttempts(ctx context.Context, scheduledFor pgtyp
 			&i.WebhookServiceID,
 			&i.DeliveryStatus,
 			&i.CreatedAt_3,
-			&i.IdempotencyKey,
+			&i.IdempotencyKey_2,
+			&i.VerifiedSecret,
+			&i.ContentType,
+			&i.ContentEncoding,
+			&i.BodyOid,
+			&i.DedupKey,
+			&i.EventType,
 		); err != nil {
 			return nil, err
 		}
@@ -390,7 +751,7 @@ func (q *Queries) GetDueDeliveryAttempts(ctx context.Context, scheduledFor pgtyp
 }
 
 const getMostRecentDeliveryAttemptByWebhookId = `-- name: GetMostRecentDeliveryAttemptByWebhookId :one
-SELECT da.id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, da.created_at, da.hash_value, worker_name, wt.id, webhook_id, forwarder_id, wt.created_at, wt.hash_value FROM delivery_attempts da
+SELECT da.id, target_id, da.status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, da.created_at, da.hash_value, worker_name, request_body, request_headers, wt.id, webhook_id, forwarder_id, wt.created_at, wt.hash_value, wt.status, ordering_key, idempotency_key FROM delivery_attempts da
          JOIN webhook_targets wt ON da.target_id = wt.id
 WHERE wt.webhook_id = $1
 ORDER BY da.created_at DESC
@@ -410,11 +771,16 @@ type GetMostRecentDeliveryAttemptByWebhookIdRow struct {
 	CreatedAt       pgtype.Timestamptz
 	HashValue       int64
 	WorkerName      pgtype.Text
+	RequestBody     []byte
+	RequestHeaders  []byte
 	ID_2            int64
 	WebhookID       pgtype.Int8
 	ForwarderID     string
 	CreatedAt_2     pgtype.Timestamptz
 	HashValue_2     int64
+	Status_2        DeliveryStatus
+	OrderingKey     pgtype.Text
+	IdempotencyKey  pgtype.Text
 }
 
 func (q *Queries) GetMostRecentDeliveryAttemptByWebhookId(ctx context.Context, webhookID pgtype.Int8) (GetMostRecentDeliveryAttemptByWebhookIdRow, error) {
@@ -433,19 +799,47 @@ func (q *Queries) GetMostRecentDeliveryAttemptByWebhookId(ctx context.Context, w
 		&i.CreatedAt,
 		&i.HashValue,
 		&i.WorkerName,
+		&i.RequestBody,
+		&i.RequestHeaders,
 		&i.ID_2,
 		&i.WebhookID,
 		&i.ForwarderID,
 		&i.CreatedAt_2,
 		&i.HashValue_2,
+		&i.Status_2,
+		&i.OrderingKey,
+		&i.IdempotencyKey,
 	)
 	return i, err
 }
 
+const getRateLimitWait = `-- name: GetRateLimitWait :one
+SELECT (GREATEST(1 - (tokens + EXTRACT(EPOCH FROM NOW() - refilled_at)::double precision * $1::double precision), 0) /
+        $1::double precision)::double precision AS wait_seconds
+FROM rate_limit_buckets
+WHERE webhook_service_id = $2 AND forwarder_id = $3
+`
+
+type GetRateLimitWaitParams struct {
+	Rate             float64
+	WebhookServiceID string
+	ForwarderID      string
+}
+
+// Seconds until the bucket of a forwarder refilled to a whole token.
+func (q *Queries) GetRateLimitWait(ctx context.Context, arg GetRateLimitWaitParams) (float64, error) {
+	row := q.db.QueryRow(ctx, getRateLimitWait, arg.Rate, arg.WebhookServiceID, arg.ForwarderID)
+	var wait_seconds float64
+	err := row.Scan(&wait_seconds)
+	return wait_seconds, err
+}
+
 const getSortedHashRing = `-- name: GetSortedHashRing :many
-SELECT id, node_name, virtual_id, hash_key FROM hash_ring ORDER BY hash_key
+SELECT id, node_name, virtual_id, hash_key, heartbeat_at FROM hash_ring ORDER BY hash_key, node_name, virtual_id
 `
 
+// Virtual nodes on the same key are ordered by name as well, so every worker agrees on which of
+// them owns the range after the key.
 func (q *Queries) GetSortedHashRing(ctx context.Context) ([]HashRing, error) {
 	rows, err := q.db.Query(ctx, getSortedHashRing)
 	if err != nil {
@@ -460,6 +854,87 @@ func (q *Queries) GetSortedHashRing(ctx context.Context) ([]HashRing, error) {
 			&i.NodeName,
 			&i.VirtualID,
 			&i.HashKey,
+			&i.HeartbeatAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSecretUsage = `-- name: GetSecretUsage :many
+SELECT
+    webhook_service_id,
+    verified_secret::text AS secret_name,
+    max(created_at)::timestamptz AS last_used_at,
+    count(*) AS event_count
+FROM webhooks
+WHERE verified_secret IS NOT NULL
+GROUP BY webhook_service_id, verified_secret
+`
+
+type GetSecretUsageRow struct {
+	WebhookServiceID string
+	SecretName       string
+	LastUsedAt       pgtype.Timestamptz
+	EventCount       int64
+}
+
+func (q *Queries) GetSecretUsage(ctx context.Context) ([]GetSecretUsageRow, error) {
+	rows, err := q.db.Query(ctx, getSecretUsage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSecretUsageRow
+	for rows.Next() {
+		var i GetSecretUsageRow
+		if err := rows.Scan(
+			&i.WebhookServiceID,
+			&i.SecretName,
+			&i.LastUsedAt,
+			&i.EventCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTrippedCircuitBreakers = `-- name: GetTrippedCircuitBreakers :many
+SELECT webhook_service_id, forwarder_id, state, consecutive_failures, window_started_at, window_total, window_failures, probes, opened_at, retry_at, updated_at FROM circuit_breakers
+WHERE state <> 'closed'
+`
+
+func (q *Queries) GetTrippedCircuitBreakers(ctx context.Context) ([]CircuitBreaker, error) {
+	rows, err := q.db.Query(ctx, getTrippedCircuitBreakers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CircuitBreaker
+	for rows.Next() {
+		var i CircuitBreaker
+		if err := rows.Scan(
+			&i.WebhookServiceID,
+			&i.ForwarderID,
+			&i.State,
+			&i.ConsecutiveFailures,
+			&i.WindowStartedAt,
+			&i.WindowTotal,
+			&i.WindowFailures,
+			&i.Probes,
+			&i.OpenedAt,
+			&i.RetryAt,
+			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -472,7 +947,7 @@ func (q *Queries) GetSortedHashRing(ctx context.Context) ([]HashRing, error) {
 }
 
 const getUnprocessedWebhooks = `-- name: GetUnprocessedWebhooks :many
-SELECT id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key FROM webhooks
+SELECT id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type FROM webhooks
 WHERE delivery_status = 'future'
 `
 
@@ -497,6 +972,12 @@ func (q *Queries) GetUnprocessedWebhooks(ctx context.Context) ([]Webhook, error)
 			&i.DeliveryStatus,
 			&i.CreatedAt,
 			&i.IdempotencyKey,
+			&i.VerifiedSecret,
+			&i.ContentType,
+			&i.ContentEncoding,
+			&i.BodyOid,
+			&i.DedupKey,
+			&i.EventType,
 		); err != nil {
 			return nil, err
 		}
@@ -508,9 +989,83 @@ func (q *Queries) GetUnprocessedWebhooks(ctx context.Context) ([]Webhook, error)
 	return items, nil
 }
 
+const getWebhookByDedupKey = `-- name: GetWebhookByDedupKey :one
+SELECT id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type FROM webhooks
+WHERE webhook_service_id = $1 AND dedup_key = $2 AND created_at >= $3
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+type GetWebhookByDedupKeyParams struct {
+	WebhookServiceID string
+	DedupKey         pgtype.Text
+	CreatedAt        pgtype.Timestamptz
+}
+
+// Returns the latest event with the key that is still inside the dedup window.
+func (q *Queries) GetWebhookByDedupKey(ctx context.Context, arg GetWebhookByDedupKeyParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, getWebhookByDedupKey, arg.WebhookServiceID, arg.DedupKey, arg.CreatedAt)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Url,
+		&i.Method,
+		&i.Body,
+		&i.Headers,
+		&i.QueryParams,
+		&i.WebhookServiceID,
+		&i.DeliveryStatus,
+		&i.CreatedAt,
+		&i.IdempotencyKey,
+		&i.VerifiedSecret,
+		&i.ContentType,
+		&i.ContentEncoding,
+		&i.BodyOid,
+		&i.DedupKey,
+		&i.EventType,
+	)
+	return i, err
+}
+
+const getWebhookByName = `-- name: GetWebhookByName :one
+SELECT id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type FROM webhooks
+WHERE webhook_service_id = $1 AND name = $2
+`
+
+type GetWebhookByNameParams struct {
+	WebhookServiceID string
+	Name             string
+}
+
+func (q *Queries) GetWebhookByName(ctx context.Context, arg GetWebhookByNameParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, getWebhookByName, arg.WebhookServiceID, arg.Name)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Url,
+		&i.Method,
+		&i.Body,
+		&i.Headers,
+		&i.QueryParams,
+		&i.WebhookServiceID,
+		&i.DeliveryStatus,
+		&i.CreatedAt,
+		&i.IdempotencyKey,
+		&i.VerifiedSecret,
+		&i.ContentType,
+		&i.ContentEncoding,
+		&i.BodyOid,
+		&i.DedupKey,
+		&i.EventType,
+	)
+	return i, err
+}
+
 const getWebhookTargetDetails = `-- name: GetWebhookTargetDetails :one
 SELECT
-    wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value,
+    wt.id, wt.webhook_id, wt.forwarder_id, wt.created_at, wt.hash_value, wt.status, wt.ordering_key, wt.idempotency_key,
     w.webhook_service_id,
     w.url,
     count(da.id) as attempt_count
@@ -527,6 +1082,9 @@ type GetWebhookTargetDetailsRow struct {
 	ForwarderID      string
 	CreatedAt        pgtype.Timestamptz
 	HashValue        int64
+	Status           DeliveryStatus
+	OrderingKey      pgtype.Text
+	IdempotencyKey   pgtype.Text
 	WebhookServiceID string
 	Url              string
 	AttemptCount     int64
@@ -541,6 +1099,9 @@ func (q *Queries) GetWebhookTargetDetails(ctx context.Context, id int64) (GetWeb
 		&i.ForwarderID,
 		&i.CreatedAt,
 		&i.HashValue,
+		&i.Status,
+		&i.OrderingKey,
+		&i.IdempotencyKey,
 		&i.WebhookServiceID,
 		&i.Url,
 		&i.AttemptCount,
@@ -567,7 +1128,7 @@ SELECT
     wt.forwarder_id,
     wt.created_at,
     w.webhook_service_id,
-    COALESCE(la.status, 'future'::delivery_status) as status,
+    wt.status,
     la.response_code,
     COALESCE(ac.attempt_count, 0) as attempt_count
 FROM webhook_targets wt
@@ -577,7 +1138,7 @@ FROM webhook_targets wt
 WHERE
     ($1::text = '' OR w.webhook_service_id = $1) AND
     ($2::text = '' OR wt.forwarder_id = $2) AND
-    ($3::text = '' OR la.status = $3::delivery_status) AND
+    ($3::text = '' OR wt.status = $3::delivery_status) AND
     ($4::bigint = 0 OR wt.id < $4)
 ORDER BY wt.id DESC
 LIMIT $5
@@ -636,7 +1197,7 @@ func (q *Queries) GetWebhookTargetsList(ctx context.Context, arg GetWebhookTarge
 }
 
 const getWebhooksByServiceId = `-- name: GetWebhooksByServiceId :many
-SELECT id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key FROM webhooks
+SELECT id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type FROM webhooks
 WHERE webhook_service_id = $1 ORDER BY created_at DESC
 `
 
@@ -661,6 +1222,12 @@ func (q *Queries) GetWebhooksByServiceId(ctx context.Context, webhookServiceID s
 			&i.DeliveryStatus,
 			&i.CreatedAt,
 			&i.IdempotencyKey,
+			&i.VerifiedSecret,
+			&i.ContentType,
+			&i.ContentEncoding,
+			&i.BodyOid,
+			&i.DedupKey,
+			&i.EventType,
 		); err != nil {
 			return nil, err
 		}
@@ -673,9 +1240,10 @@ func (q *Queries) GetWebhooksByServiceId(ctx context.Context, webhookServiceID s
 }
 
 const insertWebhookEvent = `-- name: InsertWebhookEvent :one
-INSERT INTO webhooks (name, url, webhook_service_id, method, body, headers, query_params)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
-RETURNING id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key
+INSERT INTO webhooks (name, url, webhook_service_id, method, body, headers, query_params, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (webhook_service_id, name) DO NOTHING
+RETURNING id, name, url, method, body, headers, query_params, webhook_service_id, delivery_status, created_at, idempotency_key, verified_secret, content_type, content_encoding, body_oid, dedup_key, event_type
 `
 
 type InsertWebhookEventParams struct {
@@ -683,11 +1251,18 @@ type InsertWebhookEventParams struct {
 	Url              string
 	WebhookServiceID string
 	Method           string
-	Body             string
+	Body             []byte
 	Headers          []byte
 	QueryParams      []byte
+	VerifiedSecret   pgtype.Text
+	ContentType      pgtype.Text
+	ContentEncoding  pgtype.Text
+	BodyOid          pgtype.Int8
+	DedupKey         pgtype.Text
+	EventType        pgtype.Text
 }
 
+// Names are unique per service, so a provider message ID used as the name deduplicates retries.
 func (q *Queries) InsertWebhookEvent(ctx context.Context, arg InsertWebhookEventParams) (Webhook, error) {
 	row := q.db.QueryRow(ctx, insertWebhookEvent,
 		arg.Name,
@@ -697,6 +1272,12 @@ func (q *Queries) InsertWebhookEvent(ctx context.Context, arg InsertWebhookEvent
 		arg.Body,
 		arg.Headers,
 		arg.QueryParams,
+		arg.VerifiedSecret,
+		arg.ContentType,
+		arg.ContentEncoding,
+		arg.BodyOid,
+		arg.DedupKey,
+		arg.EventType,
 	)
 	var i Webhook
 	err := row.Scan(
@@ -711,24 +1292,38 @@ func (q *Queries) InsertWebhookEvent(ctx context.Context, arg InsertWebhookEvent
 		&i.DeliveryStatus,
 		&i.CreatedAt,
 		&i.IdempotencyKey,
+		&i.VerifiedSecret,
+		&i.ContentType,
+		&i.ContentEncoding,
+		&i.BodyOid,
+		&i.DedupKey,
+		&i.EventType,
 	)
 	return i, err
 }
 
 const insertWebhookTarget = `-- name: InsertWebhookTarget :one
-INSERT INTO webhook_targets (webhook_id, forwarder_id, hash_value)
-VALUES ($1, $2, $3)
-RETURNING id, webhook_id, forwarder_id, created_at, hash_value
+INSERT INTO webhook_targets (webhook_id, forwarder_id, hash_value, ordering_key, idempotency_key)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, webhook_id, forwarder_id, created_at, hash_value, status, ordering_key, idempotency_key
 `
 
 type InsertWebhookTargetParams struct {
-	WebhookID   pgtype.Int8
-	ForwarderID string
-	HashValue   int64
+	WebhookID      pgtype.Int8
+	ForwarderID    string
+	HashValue      int64
+	OrderingKey    pgtype.Text
+	IdempotencyKey pgtype.Text
 }
 
 func (q *Queries) InsertWebhookTarget(ctx context.Context, arg InsertWebhookTargetParams) (WebhookTarget, error) {
-	row := q.db.QueryRow(ctx, insertWebhookTarget, arg.WebhookID, arg.ForwarderID, arg.HashValue)
+	row := q.db.QueryRow(ctx, insertWebhookTarget,
+		arg.WebhookID,
+		arg.ForwarderID,
+		arg.HashValue,
+		arg.OrderingKey,
+		arg.IdempotencyKey,
+	)
 	var i WebhookTarget
 	err := row.Scan(
 		&i.ID,
@@ -736,22 +1331,57 @@ func (q *Queries) InsertWebhookTarget(ctx context.Context, arg InsertWebhookTarg
 		&i.ForwarderID,
 		&i.CreatedAt,
 		&i.HashValue,
+		&i.Status,
+		&i.OrderingKey,
+		&i.IdempotencyKey,
 	)
 	return i, err
 }
 
+const lockDedupKey = `-- name: LockDedupKey :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))
+`
+
+// Serializes concurrent requests with the same dedup key until the transaction ends.
+func (q *Queries) LockDedupKey(ctx context.Context, lockKey string) error {
+	_, err := q.db.Exec(ctx, lockDedupKey, lockKey)
+	return err
+}
+
+const lockOrderingKey = `-- name: LockOrderingKey :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))
+`
+
+// Serializes concurrent events with the same ordering key until the transaction ends.
+func (q *Queries) LockOrderingKey(ctx context.Context, lockKey string) error {
+	_, err := q.db.Exec(ctx, lockOrderingKey, lockKey)
+	return err
+}
+
 const markDeliveryAttemptAsFailed = `-- name: MarkDeliveryAttemptAsFailed :exec
-UPDATE delivery_attempts SET status = 'failed', executed_at=now(), error_message = $2
+UPDATE delivery_attempts SET
+status = 'failed', executed_at=now(), error_message = $2,
+response_code = $3, response_body = $4, response_headers = $5
 WHERE id = $1
 `
 
 type MarkDeliveryAttemptAsFailedParams struct {
-	ID           int64
-	ErrorMessage pgtype.Text
+	ID              int64
+	ErrorMessage    pgtype.Text
+	ResponseCode    pgtype.Int4
+	ResponseBody    pgtype.Text
+	ResponseHeaders []byte
 }
 
+// The response is only set when the forwarder answered with an unsuccessful status.
 func (q *Queries) MarkDeliveryAttemptAsFailed(ctx context.Context, arg MarkDeliveryAttemptAsFailedParams) error {
-	_, err := q.db.Exec(ctx, markDeliveryAttemptAsFailed, arg.ID, arg.ErrorMessage)
+	_, err := q.db.Exec(ctx, markDeliveryAttemptAsFailed,
+		arg.ID,
+		arg.ErrorMessage,
+		arg.ResponseCode,
+		arg.ResponseBody,
+		arg.ResponseHeaders,
+	)
 	return err
 }
 
@@ -789,22 +1419,131 @@ func (q *Queries) MarkWebhookAsScheduled(ctx context.Context, id int64) error {
 	return err
 }
 
-const reclaimAbandonedDeliveryAttempts = `-- name: ReclaimAbandonedDeliveryAttempts :exec
+const openCircuitBreaker = `-- name: OpenCircuitBreaker :execrows
+UPDATE circuit_breakers SET
+    state = 'open', probes = 0, opened_at = NOW(),
+    retry_at = NOW() + $1::integer * INTERVAL '1 second',
+    window_started_at = NOW(), window_total = 0, window_failures = 0, updated_at = NOW()
+WHERE webhook_service_id = $2 AND forwarder_id = $3 AND state <> 'open'
+`
+
+type OpenCircuitBreakerParams struct {
+	OpenDuration     int32
+	WebhookServiceID string
+	ForwarderID      string
+}
+
+// Opens the breaker for the open duration, in seconds. A breaker that is already open keeps its
+// retry time, so late results of deliveries that were still running don't extend it.
+func (q *Queries) OpenCircuitBreaker(ctx context.Context, arg OpenCircuitBreakerParams) (int64, error) {
+	result, err := q.db.Exec(ctx, openCircuitBreaker, arg.OpenDuration, arg.WebhookServiceID, arg.ForwarderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reclaimAbandonedDeliveryAttempts = `-- name: ReclaimAbandonedDeliveryAttempts :execrows
 UPDATE delivery_attempts
 SET status = 'scheduled', worker_name = NULL, executed_at = NULL
-WHERE status = 'processing' AND executed_at < NOW() - INTERVAL '10 minutes'
+WHERE status = 'processing' AND (
+    worker_name IS NULL OR
+    (NOT EXISTS (SELECT 1 FROM hash_ring hr WHERE hr.node_name = delivery_attempts.worker_name) AND
+        executed_at < NOW() - $1::integer * INTERVAL '1 second') OR
+    executed_at < NOW() - INTERVAL '10 minutes'
+)
 `
 
-func (q *Queries) ReclaimAbandonedDeliveryAttempts(ctx context.Context) error {
-	_, err := q.db.Exec(ctx, reclaimAbandonedDeliveryAttempts)
-	return err
+// Attempts are abandoned when their worker left the hash ring and they ran longer than the longest
+// forwarder timeout, in seconds, or when they run far longer than any delivery is allowed to. A worker
+// that only missed its heartbeats can still be delivering, so its attempts are left to it until then.
+func (q *Queries) ReclaimAbandonedDeliveryAttempts(ctx context.Context, deliveryTimeout int32) (int64, error) {
+	result, err := q.db.Exec(ctx, reclaimAbandonedDeliveryAttempts, deliveryTimeout)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordCircuitBreakerResult = `-- name: RecordCircuitBreakerResult :one
+INSERT INTO circuit_breakers (webhook_service_id, forwarder_id, consecutive_failures, window_total, window_failures)
+VALUES ($1, $2, CASE WHEN $3::boolean THEN 1 ELSE 0 END, 1,
+        CASE WHEN $3::boolean THEN 1 ELSE 0 END)
+ON CONFLICT (webhook_service_id, forwarder_id) DO UPDATE SET
+    consecutive_failures = CASE WHEN $3::boolean THEN circuit_breakers.consecutive_failures + 1 ELSE 0 END,
+    window_started_at = CASE WHEN circuit_breakers.window_started_at < NOW() - $4::integer * INTERVAL '1 second'
+        THEN NOW() ELSE circuit_breakers.window_started_at END,
+    window_total = CASE WHEN circuit_breakers.window_started_at < NOW() - $4::integer * INTERVAL '1 second'
+        THEN 1 ELSE circuit_breakers.window_total + 1 END,
+    window_failures = CASE WHEN circuit_breakers.window_started_at < NOW() - $4::integer * INTERVAL '1 second'
+        THEN 0 ELSE circuit_breakers.window_failures END + CASE WHEN $3::boolean THEN 1 ELSE 0 END,
+    updated_at = NOW()
+RETURNING webhook_service_id, forwarder_id, state, consecutive_failures, window_started_at, window_total, window_failures, probes, opened_at, retry_at, updated_at
+`
+
+type RecordCircuitBreakerResultParams struct {
+	WebhookServiceID string
+	ForwarderID      string
+	Failed           bool
+	Window           int32
+}
+
+// Counts a delivery in the breaker of its forwarder. The failure rate is measured over a window,
+// in seconds, a new one starts with the first delivery after the current window ended.
+func (q *Queries) RecordCircuitBreakerResult(ctx context.Context, arg RecordCircuitBreakerResultParams) (CircuitBreaker, error) {
+	row := q.db.QueryRow(ctx, recordCircuitBreakerResult,
+		arg.WebhookServiceID,
+		arg.ForwarderID,
+		arg.Failed,
+		arg.Window,
+	)
+	var i CircuitBreaker
+	err := row.Scan(
+		&i.WebhookServiceID,
+		&i.ForwarderID,
+		&i.State,
+		&i.ConsecutiveFailures,
+		&i.WindowStartedAt,
+		&i.WindowTotal,
+		&i.WindowFailures,
+		&i.Probes,
+		&i.OpenedAt,
+		&i.RetryAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const recordSignature = `-- name: RecordSignature :execrows
+INSERT INTO signature_replay_cache (webhook_service_id, replay_key, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (webhook_service_id, replay_key) DO UPDATE
+    SET expires_at = EXCLUDED.expires_at, created_at = CURRENT_TIMESTAMP
+    WHERE signature_replay_cache.expires_at < NOW()
+`
+
+type RecordSignatureParams struct {
+	WebhookServiceID string
+	ReplayKey        string
+	ExpiresAt        pgtype.Timestamptz
+}
+
+// Signatures are only remembered until they fall outside the tolerance window, after that the
+// timestamp check rejects them, so an expired row can be reused.
+func (q *Queries) RecordSignature(ctx context.Context, arg RecordSignatureParams) (int64, error) {
+	result, err := q.db.Exec(ctx, recordSignature, arg.WebhookServiceID, arg.ReplayKey, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const registerNodeInHashRing = `-- name: RegisterNodeInHashRing :one
 INSERT INTO hash_ring (node_name, virtual_id, hash_key)
 VALUES ($1, $2, $3)
-ON CONFLICT (node_name) DO NOTHING
-RETURNING id, node_name, virtual_id, hash_key
+ON CONFLICT (node_name, virtual_id) DO UPDATE
+    SET hash_key = EXCLUDED.hash_key, heartbeat_at = NOW()
+RETURNING id, node_name, virtual_id, hash_key, heartbeat_at
 `
 
 type RegisterNodeInHashRingParams struct {
@@ -813,6 +1552,7 @@ type RegisterNodeInHashRingParams struct {
 	HashKey   int64
 }
 
+// A restarted node takes its virtual nodes back under the same name.
 func (q *Queries) RegisterNodeInHashRing(ctx context.Context, arg RegisterNodeInHashRingParams) (HashRing, error) {
 	row := q.db.QueryRow(ctx, registerNodeInHashRing, arg.NodeName, arg.VirtualID, arg.HashKey)
 	var i HashRing
@@ -821,34 +1561,91 @@ func (q *Queries) RegisterNodeInHashRing(ctx context.Context, arg RegisterNodeIn
 		&i.NodeName,
 		&i.VirtualID,
 		&i.HashKey,
+		&i.HeartbeatAt,
 	)
 	return i, err
 }
 
+const releaseDeliveryAttempt = `-- name: ReleaseDeliveryAttempt :exec
+UPDATE delivery_attempts
+SET status = 'scheduled', worker_name = NULL, executed_at = NULL
+WHERE id = $1 AND status = 'processing'
+`
+
+// Puts a claimed attempt back without delivering it, so it doesn't count against the retries.
+func (q *Queries) ReleaseDeliveryAttempt(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, releaseDeliveryAttempt, id)
+	return err
+}
+
 const releaseTaskLock = `-- name: ReleaseTaskLock :exec
 DELETE FROM task_locks
-WHERE task_name = $1
+WHERE task_name = $1 AND worker_name = $2
+`
+
+type ReleaseTaskLockParams struct {
+	TaskName   string
+	WorkerName string
+}
+
+func (q *Queries) ReleaseTaskLock(ctx context.Context, arg ReleaseTaskLockParams) error {
+	_, err := q.db.Exec(ctx, releaseTaskLock, arg.TaskName, arg.WorkerName)
+	return err
+}
+
+const removeNodeFromHashRing = `-- name: RemoveNodeFromHashRing :exec
+DELETE FROM hash_ring WHERE node_name = $1
 `
 
-func (q *Queries) ReleaseTaskLock(ctx context.Context, taskName string) error {
-	_, err := q.db.Exec(ctx, releaseTaskLock, taskName)
+func (q *Queries) RemoveNodeFromHashRing(ctx context.Context, nodeName string) error {
+	_, err := q.db.Exec(ctx, removeNodeFromHashRing, nodeName)
 	return err
 }
 
+const requeueWebhookTarget = `-- name: RequeueWebhookTarget :one
+UPDATE webhook_targets SET status = 'scheduled'
+WHERE id = $1 AND status = 'dead_letter'
+RETURNING id, webhook_id, forwarder_id, created_at, hash_value, status, ordering_key, idempotency_key
+`
+
+// Moves a dead-lettered target back to scheduled, no row is returned for any other status.
+func (q *Queries) RequeueWebhookTarget(ctx context.Context, id int64) (WebhookTarget, error) {
+	row := q.db.QueryRow(ctx, requeueWebhookTarget, id)
+	var i WebhookTarget
+	err := row.Scan(
+		&i.ID,
+		&i.WebhookID,
+		&i.ForwarderID,
+		&i.CreatedAt,
+		&i.HashValue,
+		&i.Status,
+		&i.OrderingKey,
+		&i.IdempotencyKey,
+	)
+	return i, err
+}
+
 const scheduleDeliveryAttempt = `-- name: ScheduleDeliveryAttempt :one
-INSERT INTO delivery_attempts (target_id, scheduled_for, status)
-VALUES ($1, $2, $3)
-RETURNING id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, created_at, hash_value, worker_name
+INSERT INTO delivery_attempts (target_id, scheduled_for, status, hash_value)
+VALUES ($1, $2, $3, $4)
+RETURNING id, target_id, status, scheduled_for, executed_at, response_code, response_body, response_headers, error_message, created_at, hash_value, worker_name, request_body, request_headers
 `
 
 type ScheduleDeliveryAttemptParams struct {
 	TargetID     pgtype.Int8
 	ScheduledFor pgtype.Timestamptz
 	Status       DeliveryStatus
+	HashValue    int64
 }
 
+// The hash value is copied from the target, it decides which worker delivers the attempt.
 func (q *Queries) ScheduleDeliveryAttempt(ctx context.Context, arg ScheduleDeliveryAttemptParams) (DeliveryAttempt, error) {
-	row := q.db.QueryRow(ctx, scheduleDeliveryAttempt, arg.TargetID, arg.ScheduledFor, arg.Status)
+	row := q.db.QueryRow(ctx, scheduleDeliveryAttempt,
+		arg.TargetID,
+		arg.ScheduledFor,
+		arg.Status,
+		arg.HashValue,
+	)
 	var i DeliveryAttempt
 	err := row.Scan(
 		&i.ID,
@@ -863,10 +1660,29 @@ func (q *Queries) ScheduleDeliveryAttempt(ctx context.Context, arg ScheduleDeliv
 		&i.CreatedAt,
 		&i.HashValue,
 		&i.WorkerName,
+		&i.RequestBody,
+		&i.RequestHeaders,
 	)
 	return i, err
 }
 
+const setDeliveryAttemptRequest = `-- name: SetDeliveryAttemptRequest :exec
+UPDATE delivery_attempts SET request_body = $2, request_headers = $3
+WHERE id = $1
+`
+
+type SetDeliveryAttemptRequestParams struct {
+	ID             int64
+	RequestBody    []byte
+	RequestHeaders []byte
+}
+
+// Records the request as it was sent to the forwarder, after any transform.
+func (q *Queries) SetDeliveryAttemptRequest(ctx context.Context, arg SetDeliveryAttemptRequestParams) error {
+	_, err := q.db.Exec(ctx, setDeliveryAttemptRequest, arg.ID, arg.RequestBody, arg.RequestHeaders)
+	return err
+}
+
 const setWebhookIdempotencyKey = `-- name: SetWebhookIdempotencyKey :exec
 UPDATE webhooks SET idempotency_key = $2
 WHERE id = $1
@@ -882,13 +1698,73 @@ func (q *Queries) SetWebhookIdempotencyKey(ctx context.Context, arg SetWebhookId
 	return err
 }
 
-const touchLock = `-- name: TouchLock :exec
-UPDATE task_locks SET touched_at = NOW() WHERE task_name = $1
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets (webhook_service_id, forwarder_id, tokens, refilled_at)
+VALUES ($1, $2, $3::double precision - 1, NOW())
+ON CONFLICT (webhook_service_id, forwarder_id) DO UPDATE
+    SET tokens = LEAST($3::double precision, rate_limit_buckets.tokens +
+            EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.refilled_at)::double precision * $4::double precision) - 1,
+        refilled_at = NOW()
+    WHERE LEAST($3::double precision, rate_limit_buckets.tokens +
+            EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.refilled_at)::double precision * $4::double precision) >= 1
+RETURNING webhook_service_id, forwarder_id, tokens, refilled_at
 `
 
-func (q *Queries) TouchLock(ctx context.Context, taskName string) error {
-	_, err := q.db.Exec(ctx, touchLock, taskName)
-	return err
+type TakeRateLimitTokenParams struct {
+	WebhookServiceID string
+	ForwarderID      string
+	Burst            float64
+	Rate             float64
+}
+
+// Takes a token from the bucket of a forwarder, which refills at rate tokens per second up to
+// burst. No row is returned while the bucket is empty.
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (RateLimitBucket, error) {
+	row := q.db.QueryRow(ctx, takeRateLimitToken,
+		arg.WebhookServiceID,
+		arg.ForwarderID,
+		arg.Burst,
+		arg.Rate,
+	)
+	var i RateLimitBucket
+	err := row.Scan(
+		&i.WebhookServiceID,
+		&i.ForwarderID,
+		&i.Tokens,
+		&i.RefilledAt,
+	)
+	return i, err
+}
+
+const touchHashRingNode = `-- name: TouchHashRingNode :execrows
+UPDATE hash_ring SET heartbeat_at = NOW() WHERE node_name = $1
+`
+
+// No row is affected once the node expired, it has to register again.
+func (q *Queries) TouchHashRingNode(ctx context.Context, nodeName string) (int64, error) {
+	result, err := q.db.Exec(ctx, touchHashRingNode, nodeName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const touchLock = `-- name: TouchLock :execrows
+UPDATE task_locks SET touched_at = NOW() WHERE task_name = $1 AND worker_name = $2
+`
+
+type TouchLockParams struct {
+	TaskName   string
+	WorkerName string
+}
+
+// No row is affected once another worker took the lock over.
+func (q *Queries) TouchLock(ctx context.Context, arg TouchLockParams) (int64, error) {
+	result, err := q.db.Exec(ctx, touchLock, arg.TaskName, arg.WorkerName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const updateWebhookDeliveryStatus = `-- name: UpdateWebhookDeliveryStatus :exec
@@ -905,3 +1781,19 @@ func (q *Queries) UpdateWebhookDeliveryStatus(ctx context.Context, arg UpdateWeb
 	_, err := q.db.Exec(ctx, updateWebhookDeliveryStatus, arg.ID, arg.DeliveryStatus)
 	return err
 }
+
+const updateWebhookTargetStatus = `-- name: UpdateWebhookTargetStatus :exec
+UPDATE webhook_targets SET status = $2
+WHERE id = $1
+`
+
+type UpdateWebhookTargetStatusParams struct {
+	ID     int64
+	Status DeliveryStatus
+}
+
+// Targets are failed once their forwarder's retry policy is exhausted.
+func (q *Queries) UpdateWebhookTargetStatus(ctx context.Context, arg UpdateWebhookTargetStatusParams) error {
+	_, err := q.db.Exec(ctx, updateWebhookTargetStatus, arg.ID, arg.Status)
+	return err
+}
@@ -1,13 +1,19 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
+	"laile/internal/config"
 	"laile/internal/event"
 	"laile/internal/log"
 	db_models "laile/internal/postgresql"
@@ -38,6 +44,10 @@ func (s *Server) RegisterAdminRoutes() http.Handler {
 	mux.HandleFunc("/admin/dashboard", s.adminDashboardHandler)
 	mux.HandleFunc("/admin/delivery-attempts", s.deliveryAttemptsHandler)
 	mux.HandleFunc("/admin/targets/", s.targetDetailsHandler)
+	mux.HandleFunc("/admin/secrets", s.secretsHandler)
+	mux.HandleFunc("/admin/circuit-breakers", s.circuitBreakersHandler)
+	mux.HandleFunc("/admin/dead-letters", s.deadLettersHandler)
+	mux.HandleFunc("POST /admin/dead-letters/{id}/requeue", s.requeueDeadLetterHandler)
 
 	return handler
 }
@@ -57,13 +67,26 @@ func (s *Server) WebhookListenerHandler(w http.ResponseWriter, r *http.Request)
 	// Extract listener from path
 	listener := strings.TrimPrefix(r.URL.Path, "/listener/")
 
-	err := event.HandleEvent(s.db, listener, r, s.config)
-	if err != nil {
+	result, err := event.HandleEvent(s.db, listener, r, s.config)
+	switch {
+	case errors.Is(err, event.ErrServiceNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": "not_found"})
+		return
+	case errors.Is(err, event.ErrUnauthorized):
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "unauthorized"})
+		return
+	case errors.Is(err, event.ErrPayloadTooLarge):
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"status": "payload_too_large"})
+		return
+	case err != nil:
 		log.Logger.Error("webhook handler error", "error", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error"})
 		return
+	case result.Challenge != nil:
+		writeChallenge(w, result.Challenge)
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeEventResponse(w, r, result)
 }
 
 func (s *Server) adminStatusHandler(w http.ResponseWriter, _ *http.Request) {
@@ -192,6 +215,164 @@ func (s *Server) targetDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type DeadLettersResponse struct {
+	Items   []db_models.GetDeadLetterTargetsRow
+	HasMore bool
+	LastID  int64
+}
+
+// deadLettersHandler lists the targets that ran out of retries.
+func (s *Server) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	cursor := r.URL.Query().Get("cursor")
+
+	var cursorID int64
+	if cursor != "" {
+		var err error
+		cursorID, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			log.Logger.Error("invalid cursor", "error", err)
+			err = adminTemplate.ExecuteTemplate(w, "error", "Invalid cursor")
+			if err != nil {
+				http.Error(w, "failed to render error page", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	const pageSize = 20
+	targets, err := s.db.Queries().GetDeadLetterTargets(r.Context(), db_models.GetDeadLetterTargetsParams{
+		ServiceID: service,
+		Cursor:    cursorID,
+		PageSize:  pageSize + 1, // fetch one extra to check if there are more
+	})
+	if err != nil {
+		log.Logger.Error("failed to list dead-lettered targets", "error", err)
+		err = adminTemplate.ExecuteTemplate(w, "error", "Failed to load dead letters")
+		if err != nil {
+			http.Error(w, "failed to render error page", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	hasMore := len(targets) > pageSize
+	if hasMore {
+		targets = targets[:pageSize] // remove the extra item
+	}
+
+	var lastID int64
+	if len(targets) > 0 {
+		lastID = targets[len(targets)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = adminTemplate.ExecuteTemplate(w, "dead_letters", DeadLettersResponse{
+		Items:   targets,
+		HasMore: hasMore,
+		LastID:  lastID,
+	})
+	if err != nil {
+		http.Error(w, "failed to render dead letters", http.StatusInternalServerError)
+	}
+}
+
+// requeueDeadLetterHandler schedules a new attempt for a dead-lettered target and renders the
+// remaining dead letters.
+func (s *Server) requeueDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	targetID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid target ID", http.StatusBadRequest)
+		return
+	}
+
+	err = event.RequeueDeadLetter(r.Context(), s.db, targetID)
+	if err != nil {
+		message := "Failed to requeue target"
+		if errors.Is(err, event.ErrNotDeadLettered) {
+			message = "Target is no longer dead-lettered"
+		} else {
+			log.Logger.Error("failed to requeue dead-lettered target", "error", err, "target_id", targetID)
+		}
+		err = adminTemplate.ExecuteTemplate(w, "error", message)
+		if err != nil {
+			http.Error(w, "failed to render error page", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Logger.Info("requeued dead-lettered target", "target_id", targetID)
+	s.deadLettersHandler(w, r)
+}
+
+type SecretUsage struct {
+	ServiceID  string
+	SecretName string
+	NotAfter   time.Time
+	Expired    bool
+	LastUsedAt pgtype.Timestamptz
+	EventCount int64
+}
+
+// secretsHandler lists every configured signing secret with the last time it verified a request,
+// so rotated secrets can be removed once providers stop using them.
+func (s *Server) secretsHandler(w http.ResponseWriter, r *http.Request) {
+	usageRows, err := s.db.Queries().GetSecretUsage(r.Context())
+	if err != nil {
+		log.Logger.Error("failed to get secret usage", "error", err)
+		err = adminTemplate.ExecuteTemplate(w, "error", "Failed to load secret usage")
+		if err != nil {
+			http.Error(w, "failed to render error page", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	usageBySecret := make(map[string]db_models.GetSecretUsageRow, len(usageRows))
+	for _, row := range usageRows {
+		usageBySecret[row.WebhookServiceID+"/"+row.SecretName] = row
+	}
+
+	now := time.Now()
+	var secrets []SecretUsage
+	for _, serviceName := range slices.Sorted(maps.Keys(s.config.WebhookServices)) {
+		for _, secret := range s.config.WebhookServices[serviceName].AuthenticationSecrets {
+			usage := usageBySecret[serviceName+"/"+secret.Name]
+			secrets = append(secrets, SecretUsage{
+				ServiceID:  serviceName,
+				SecretName: secret.Name,
+				NotAfter:   secret.NotAfter,
+				Expired:    !secret.Active(now),
+				LastUsedAt: usage.LastUsedAt,
+				EventCount: usage.EventCount,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = adminTemplate.ExecuteTemplate(w, "secrets", secrets)
+	if err != nil {
+		http.Error(w, "failed to render secrets", http.StatusInternalServerError)
+	}
+}
+
+// circuitBreakersHandler lists the breakers of every forwarder that had a delivery since it got a
+// circuit_breaker table, open ones first.
+func (s *Server) circuitBreakersHandler(w http.ResponseWriter, r *http.Request) {
+	breakers, err := s.db.Queries().GetCircuitBreakers(r.Context())
+	if err != nil {
+		log.Logger.Error("failed to get circuit breakers", "error", err)
+		err = adminTemplate.ExecuteTemplate(w, "error", "Failed to load circuit breakers")
+		if err != nil {
+			http.Error(w, "failed to render error page", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = adminTemplate.ExecuteTemplate(w, "circuit_breakers", breakers)
+	if err != nil {
+		http.Error(w, "failed to render circuit breakers", http.StatusInternalServerError)
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -201,6 +382,47 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	}
 }
 
+func writeChallenge(w http.ResponseWriter, challenge *event.ChallengeResponse) {
+	for name, values := range challenge.Headers {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(challenge.StatusCode)
+	_, err := w.Write(challenge.Body)
+	if err != nil {
+		log.Logger.Error("failed to write challenge response", slog.Any("error", err))
+	}
+}
+
+func writeEventResponse(w http.ResponseWriter, r *http.Request, result *event.HandleResult) {
+	response := result.Service.Response
+
+	// The event is already stored, so a broken template must not make the sender retry.
+	var body bytes.Buffer
+	err := response.BodyTemplate.Execute(&body, config.NewResponseData(result.EventID, result.IdempotencyKey, result.Duplicate, r))
+	if err != nil {
+		log.Logger.Error("failed to render webhook response body", slog.Any("error", err),
+			slog.String("service_id", result.Service.Name),
+			slog.Int64("event_id", result.EventID))
+		body.Reset()
+		body.WriteString(config.DefaultResponseBody)
+		w.Header().Set("Content-Type", "application/json")
+	} else if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(response.EventIDHeader, strconv.FormatInt(result.EventID, 10))
+	w.WriteHeader(response.StatusCode)
+	_, err = w.Write(body.Bytes())
+	if err != nil {
+		log.Logger.Error("failed to write webhook response", slog.Any("error", err))
+	}
+}
+
 func logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		slog.Info("request received",
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -64,3 +66,28 @@ func NewAdminServer(db database.Service, config *config.Config) *http.Server {
 
 	return server
 }
+
+// Run serves requests until the context is done. It then stops accepting connections and waits up
+// to the timeout for the running requests to finish.
+func Run(ctx context.Context, httpServer *http.Server, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("cannot serve on %s: %w", httpServer.Addr, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("cannot shut down server on %s: %w", httpServer.Addr, err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("cannot serve on %s: %w", httpServer.Addr, err)
+	}
+	return nil
+}
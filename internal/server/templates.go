@@ -23,7 +23,7 @@ var adminTemplate = template.Must(template.New("").Parse(`
         <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .ForwarderID }}</td>
         <td class="px-6 py-4 whitespace-nowrap text-sm 
           {{ if eq .Status "success" }}text-green-600
-          {{ else if eq .Status "failed" }}text-red-600
+          {{ else if or (eq .Status "failed") (eq .Status "dead_letter") }}text-red-600
           {{ else if eq .Status "scheduled" }}text-yellow-600
           {{ else }}text-gray-600{{ end }}">
           {{ .Status }}
@@ -48,6 +48,129 @@ var adminTemplate = template.Must(template.New("").Parse(`
 </div>
 {{ end }}
 
+{{ define "dead_letters" }}
+<div class="bg-white shadow overflow-hidden rounded-lg">
+  <table class="min-w-full divide-y divide-gray-200">
+    <thead class="bg-gray-50">
+      <tr>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Target ID</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Service</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Forwarder</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Event Type</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Attempts</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Last Error</th>
+        <th scope="col" class="px-6 py-3"></th>
+      </tr>
+    </thead>
+    <tbody class="bg-white divide-y divide-gray-200">
+      {{ range .Items }}
+      <tr class="hover:bg-gray-50">
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-blue-600 hover:text-blue-800"><a href="/admin/targets/{{ .ID }}">{{ .ID }}</a></td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .WebhookServiceID }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .ForwarderID }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .EventType.String }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .AttemptCount }}</td>
+        <td class="px-6 py-4 text-sm text-red-600">
+          {{ .ErrorMessage.String }}
+          {{ if .ExecutedAt.Valid }}<span class="block text-gray-500">{{ .ExecutedAt.Time.Format "2006-01-02 15:04:05" }}</span>{{ end }}
+        </td>
+        <td class="px-6 py-4 whitespace-nowrap text-right text-sm">
+          <button class="bg-blue-600 hover:bg-blue-700 text-white font-medium py-1 px-3 rounded-md"
+                  hx-post="/admin/dead-letters/{{ .ID }}/requeue"
+                  hx-target="#dead-letters"
+                  hx-swap="innerHTML">
+            Requeue
+          </button>
+        </td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+  {{ if .HasMore }}
+  <div class="p-4">
+    <button class="w-full bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-4 rounded-md"
+            hx-get="/admin/dead-letters"
+            hx-target="#dead-letters"
+            hx-swap="innerHTML"
+            hx-vals='{"cursor": "{{ .LastID }}"}'>
+      Load More
+    </button>
+  </div>
+  {{ end }}
+</div>
+{{ end }}
+
+{{ define "secrets" }}
+<div class="bg-white shadow overflow-hidden rounded-lg">
+  <table class="min-w-full divide-y divide-gray-200">
+    <thead class="bg-gray-50">
+      <tr>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Service</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Secret</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Not After</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Last Used</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Events</th>
+      </tr>
+    </thead>
+    <tbody class="bg-white divide-y divide-gray-200">
+      {{ range . }}
+      <tr class="hover:bg-gray-50">
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .ServiceID }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .SecretName }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm {{ if .Expired }}text-red-600{{ else }}text-gray-900{{ end }}">
+          {{ if .NotAfter.IsZero }}never{{ else }}{{ .NotAfter.Format "2006-01-02 15:04:05" }}{{ end }}
+        </td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">
+          {{ if .LastUsedAt.Valid }}{{ .LastUsedAt.Time.Format "2006-01-02 15:04:05" }}{{ else }}never{{ end }}
+        </td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .EventCount }}</td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+</div>
+{{ end }}
+
+{{ define "circuit_breakers" }}
+<div class="bg-white shadow overflow-hidden rounded-lg">
+  <table class="min-w-full divide-y divide-gray-200">
+    <thead class="bg-gray-50">
+      <tr>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Service</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Forwarder</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">State</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Consecutive Failures</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Window Failures</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Opened At</th>
+        <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Retry At</th>
+      </tr>
+    </thead>
+    <tbody class="bg-white divide-y divide-gray-200">
+      {{ range . }}
+      <tr class="hover:bg-gray-50">
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .WebhookServiceID }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .ForwarderID }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm">
+          <span class="px-2 inline-flex text-xs leading-5 font-semibold rounded-full
+            {{ if eq .State "closed" }}bg-green-100 text-green-800{{ else if eq .State "open" }}bg-red-100 text-red-800{{ else }}bg-yellow-100 text-yellow-800{{ end }}">
+            {{ .State }}
+          </span>
+        </td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .ConsecutiveFailures }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .WindowFailures }} / {{ .WindowTotal }}</td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">
+          {{ if .OpenedAt.Valid }}{{ .OpenedAt.Time.Format "2006-01-02 15:04:05" }}{{ end }}
+        </td>
+        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">
+          {{ if .RetryAt.Valid }}{{ .RetryAt.Time.Format "2006-01-02 15:04:05" }}{{ end }}
+        </td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+</div>
+{{ end }}
+
 {{ define "error" }}
 <div class="max-w-lg mx-auto bg-red-50 border border-red-400 text-red-700 px-4 py-3 rounded relative" role="alert">
   <strong class="font-bold">Error!</strong>
@@ -96,9 +219,25 @@ var adminTemplate = template.Must(template.New("").Parse(`
                         <dt class="text-sm font-medium text-gray-500">Total Attempts</dt>
                         <dd class="mt-1 text-sm text-gray-900">{{ .Target.AttemptCount }}</dd>
                     </div>
+                    <div class="sm:col-span-1">
+                        <dt class="text-sm font-medium text-gray-500">Status</dt>
+                        <dd class="mt-1 text-sm text-gray-900">{{ .Target.Status }}</dd>
+                    </div>
+                    {{ if .Target.OrderingKey.Valid }}
+                    <div class="sm:col-span-1">
+                        <dt class="text-sm font-medium text-gray-500">Ordering Key</dt>
+                        <dd class="mt-1 text-sm text-gray-900">{{ .Target.OrderingKey.String }}</dd>
+                    </div>
+                    {{ end }}
+                    {{ if .Target.IdempotencyKey.Valid }}
+                    <div class="sm:col-span-2">
+                        <dt class="text-sm font-medium text-gray-500">Idempotency Key</dt>
+                        <dd class="mt-1 text-sm text-gray-900">{{ .Target.IdempotencyKey.String }}</dd>
+                    </div>
+                    {{ end }}
                     <div class="sm:col-span-2">
                         <dt class="text-sm font-medium text-gray-500">Webhook URL</dt>
-                        <dd class="mt-1 text-sm text-gray-900">{{ .Target.URL }}</dd>
+                        <dd class="mt-1 text-sm text-gray-900">{{ .Target.Url }}</dd>
                     </div>
                 </dl>
             </div>
@@ -118,6 +257,7 @@ var adminTemplate = template.Must(template.New("").Parse(`
                                 <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase">Scheduled For</th>
                                 <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase">Executed At</th>
                                 <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase">Created At</th>
+                                <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase">Sent Request</th>
                                 <th scope="col" class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase">Response</th>
                             </tr>
                         </thead>
@@ -142,6 +282,18 @@ var adminTemplate = template.Must(template.New("").Parse(`
                                 </td>
                                 <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{{ .CreatedAt.Time.Format "2006-01-02 15:04:05" }}</td>
                                 <td class="px-6 py-4 text-sm text-gray-900">
+                                    {{ if .RequestHeaders }}
+                                        <details class="cursor-pointer">
+                                            <summary class="text-blue-600 hover:text-blue-800">View Transformed Request</summary>
+                                            <pre class="mt-2 p-2 bg-gray-50 rounded text-xs overflow-x-auto">{{ printf "%s" .RequestHeaders }}</pre>
+                                            <pre class="mt-2 p-2 bg-gray-50 rounded text-xs overflow-x-auto">{{ printf "%s" .RequestBody }}</pre>
+                                        </details>
+                                    {{ end }}
+                                </td>
+                                <td class="px-6 py-4 text-sm text-gray-900">
+                                    {{ if .ErrorMessage.Valid }}
+                                        <p class="text-red-600">{{ .ErrorMessage.String }}</p>
+                                    {{ end }}
                                     {{ if .ResponseBody }}
                                         <details class="cursor-pointer">
                                             <summary class="text-blue-600 hover:text-blue-800">View Response</summary>
@@ -192,6 +344,7 @@ var adminTemplate = template.Must(template.New("").Parse(`
               <option value="success">Success</option>
               <option value="failed">Failed</option>
               <option value="scheduled">Scheduled</option>
+              <option value="dead_letter">Dead Letter</option>
             </select>
           </div>
         </div>
@@ -199,6 +352,24 @@ var adminTemplate = template.Must(template.New("").Parse(`
           <!-- Delivery attempts table will load here -->
         </div>
       </div>
+      <div class="px-4 py-6 sm:px-0">
+        <h2 class="text-lg leading-6 font-medium text-gray-900 mb-4">Dead Letters</h2>
+        <div id="dead-letters" hx-get="/admin/dead-letters" hx-trigger="load">
+          <!-- Dead-lettered targets table will load here -->
+        </div>
+      </div>
+      <div class="px-4 py-6 sm:px-0">
+        <h2 class="text-lg leading-6 font-medium text-gray-900 mb-4">Circuit Breakers</h2>
+        <div id="circuit-breakers" hx-get="/admin/circuit-breakers" hx-trigger="load, every 10s">
+          <!-- Circuit breaker table will load here -->
+        </div>
+      </div>
+      <div class="px-4 py-6 sm:px-0">
+        <h2 class="text-lg leading-6 font-medium text-gray-900 mb-4">Signing Secrets</h2>
+        <div id="secrets" hx-get="/admin/secrets" hx-trigger="load">
+          <!-- Secret usage table will load here -->
+        </div>
+      </div>
     </div>
   </main>
 </body>
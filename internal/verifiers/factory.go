@@ -0,0 +1,58 @@
+package verifiers
+
+import (
+	"fmt"
+	"sync"
+
+	"laile/internal/config"
+)
+
+// Constructor builds a Verifier from a webhook service configuration.
+type Constructor func(service *config.WebhookService) (Verifier, error)
+
+type registry struct {
+	sync.RWMutex
+	constructors map[string]Constructor
+}
+
+var globalRegistry = &registry{
+	RWMutex: sync.RWMutex{},
+	constructors: map[string]Constructor{
+		"header":            NewHeaderVerifier,
+		"hmac_sha256":       NewHMACSHA256Verifier,
+		"hmac_sha1":         NewHMACSHA1Verifier,
+		"stripe":            NewStripeVerifier,
+		"standard_webhooks": NewStandardWebhooksVerifier,
+	},
+}
+
+func init() {
+	for authenticationType := range globalRegistry.constructors {
+		config.RegisterAuthenticationType(authenticationType)
+	}
+}
+
+// Register adds or replaces the Verifier constructor used for an authentication type. Registered
+// types are accepted by the config validation.
+func Register(authenticationType string, constructor Constructor) {
+	globalRegistry.Lock()
+	defer globalRegistry.Unlock()
+	globalRegistry.constructors[authenticationType] = constructor
+	config.RegisterAuthenticationType(authenticationType)
+}
+
+// NewVerifier returns the Verifier registered for the service authentication type.
+// Services without an authentication type accept every request.
+func NewVerifier(service *config.WebhookService) (Verifier, error) {
+	if service.AuthenticationType == "" {
+		return allowAllVerifier{}, nil
+	}
+
+	globalRegistry.RLock()
+	constructor, ok := globalRegistry.constructors[service.AuthenticationType]
+	globalRegistry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no verifier registered for authentication type %q", service.AuthenticationType)
+	}
+	return constructor(service)
+}
@@ -0,0 +1,45 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"laile/internal/config"
+)
+
+// HeaderVerifier compares a header value against the service secrets.
+type HeaderVerifier struct {
+	Header  string
+	Prefix  string
+	Secrets []SigningSecret
+}
+
+func NewHeaderVerifier(service *config.WebhookService) (Verifier, error) {
+	secrets, err := signingSecrets(service, rawSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &HeaderVerifier{
+		Header:  service.AuthenticationHeader,
+		Prefix:  service.SignaturePrefix,
+		Secrets: secrets,
+	}, nil
+}
+
+func (v *HeaderVerifier) Verify(_ context.Context, request *http.Request, _ io.ReadSeeker) (*Verification, error) {
+	value, ok := strings.CutPrefix(request.Header.Get(v.Header), v.Prefix)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("%w: missing %s header", ErrUnauthorized, v.Header)
+	}
+	for _, secret := range activeSecrets(v.Secrets, time.Now()) {
+		if subtle.ConstantTimeCompare([]byte(value), secret.Key) == 1 {
+			return &Verification{SecretName: secret.Name}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s header does not match", ErrUnauthorized, v.Header)
+}
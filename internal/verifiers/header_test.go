@@ -0,0 +1,63 @@
+package verifiers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"laile/internal/config"
+)
+
+func newSignedRequest(body string, headers map[string]string) (*http.Request, *strings.Reader) {
+	request := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	return request, strings.NewReader(body)
+}
+
+func TestHeaderVerifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		headers map[string]string
+		valid   bool
+	}{
+		{name: "matching token", headers: map[string]string{"X-Token": "s3cret"}, valid: true},
+		{name: "matching token with prefix", prefix: "Bearer ", headers: map[string]string{"X-Token": "Bearer s3cret"}, valid: true},
+		{name: "prefix missing", prefix: "Bearer ", headers: map[string]string{"X-Token": "s3cret"}},
+		{name: "only the prefix", prefix: "Bearer ", headers: map[string]string{"X-Token": "Bearer "}},
+		{name: "wrong token", headers: map[string]string{"X-Token": "other"}},
+		{name: "token with a suffix", headers: map[string]string{"X-Token": "s3cret2"}},
+		{name: "missing header", headers: map[string]string{"X-Other": "s3cret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := NewHeaderVerifier(&config.WebhookService{
+				AuthenticationHeader:  "X-Token",
+				SignaturePrefix:       tt.prefix,
+				AuthenticationSecrets: []config.Secret{{Name: "current", Value: "s3cret"}},
+			})
+			if err != nil {
+				t.Fatalf("NewHeaderVerifier failed: %v", err)
+			}
+			request, body := newSignedRequest("{}", tt.headers)
+			verification, err := verifier.Verify(context.Background(), request, body)
+			if !tt.valid {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("Verify() error = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() failed: %v", err)
+			}
+			if verification.SecretName != "current" {
+				t.Errorf("SecretName = %q, want %q", verification.SecretName, "current")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" // #nosec G505: the verifier under test accepts SHA1 HMACs
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"testing"
+
+	"laile/internal/config"
+)
+
+const hmacBody = `{"action": "opened", "number": 1}`
+
+func sign(hashFunc func() hash.Hash, secret string, parts ...string) []byte {
+	mac := hmac.New(hashFunc, []byte(secret))
+	for _, part := range parts {
+		mac.Write([]byte(part))
+	}
+	return mac.Sum(nil)
+}
+
+func TestHMACVerifier(t *testing.T) {
+	sha256Hex := hex.EncodeToString(sign(sha256.New, "s3cret", hmacBody))
+	sha256Base64 := base64.StdEncoding.EncodeToString(sign(sha256.New, "s3cret", hmacBody))
+	sha1Hex := hex.EncodeToString(sign(sha1.New, "s3cret", hmacBody))
+
+	tests := []struct {
+		name      string
+		newFunc   func(*config.WebhookService) (Verifier, error)
+		encoding  string
+		prefix    string
+		signature string
+		body      string
+		valid     bool
+	}{
+		{name: "sha256 hex", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: sha256Hex, valid: true},
+		{name: "sha256 upper case hex", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: strings.ToUpper(sha256Hex), valid: true},
+		{name: "sha256 base64", newFunc: NewHMACSHA256Verifier, encoding: "base64", signature: sha256Base64, valid: true},
+		{name: "sha256 with prefix", newFunc: NewHMACSHA256Verifier, encoding: "hex", prefix: "sha256=", signature: "sha256=" + sha256Hex, valid: true},
+		{name: "sha1 with prefix", newFunc: NewHMACSHA1Verifier, encoding: "hex", prefix: "sha1=", signature: "sha1=" + sha1Hex, valid: true},
+		{name: "prefix missing", newFunc: NewHMACSHA256Verifier, encoding: "hex", prefix: "sha256=", signature: sha256Hex},
+		{name: "only the prefix", newFunc: NewHMACSHA256Verifier, encoding: "hex", prefix: "sha256=", signature: "sha256="},
+		{name: "base64 signature read as hex", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: sha256Base64},
+		{name: "hex signature read as base64", newFunc: NewHMACSHA256Verifier, encoding: "base64", signature: sha256Hex},
+		{name: "sha1 signature for sha256", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: sha1Hex},
+		{name: "wrong secret", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: hex.EncodeToString(sign(sha256.New, "other", hmacBody))},
+		{name: "changed body", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: sha256Hex, body: hmacBody + " "},
+		{name: "truncated signature", newFunc: NewHMACSHA256Verifier, encoding: "hex", signature: sha256Hex[:32]},
+		{name: "missing header", newFunc: NewHMACSHA256Verifier, encoding: "hex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := tt.newFunc(&config.WebhookService{
+				AuthenticationHeader:  "X-Signature",
+				SignatureEncoding:     tt.encoding,
+				SignaturePrefix:       tt.prefix,
+				AuthenticationSecrets: []config.Secret{{Name: "current", Value: "s3cret"}},
+			})
+			if err != nil {
+				t.Fatalf("creating the verifier failed: %v", err)
+			}
+			body := tt.body
+			if body == "" {
+				body = hmacBody
+			}
+			headers := map[string]string{}
+			if tt.signature != "" {
+				headers["X-Signature"] = tt.signature
+			}
+			request, reader := newSignedRequest(body, headers)
+			verification, err := verifier.Verify(context.Background(), request, reader)
+			if !tt.valid {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("Verify() error = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() failed: %v", err)
+			}
+			if verification.SecretName != "current" {
+				t.Errorf("SecretName = %q, want %q", verification.SecretName, "current")
+			}
+		})
+	}
+}
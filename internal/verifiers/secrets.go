@@ -0,0 +1,46 @@
+package verifiers
+
+import (
+	"fmt"
+	"time"
+
+	"laile/internal/config"
+)
+
+// SigningSecret is a service secret decoded into the key used to compute signatures.
+type SigningSecret struct {
+	config.Secret
+	Key []byte
+}
+
+// signingSecrets decodes every configured secret for a service. Expired secrets are kept so that
+// expiry is checked when a request is verified.
+func signingSecrets(service *config.WebhookService, decode func(string) ([]byte, error)) ([]SigningSecret, error) {
+	secrets := make([]SigningSecret, 0, len(service.AuthenticationSecrets))
+	for _, secret := range service.AuthenticationSecrets {
+		key, err := decode(secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode secret %s: %w", secret.Name, err)
+		}
+		secrets = append(secrets, SigningSecret{
+			Secret: secret,
+			Key:    key,
+		})
+	}
+	return secrets, nil
+}
+
+func rawSecret(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+// activeSecrets returns the secrets that are still accepted at the given time.
+func activeSecrets(secrets []SigningSecret, now time.Time) []SigningSecret {
+	active := make([]SigningSecret, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret.Active(now) {
+			active = append(active, secret)
+		}
+	}
+	return active
+}
@@ -0,0 +1,76 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"laile/internal/config"
+)
+
+func TestActiveSecrets(t *testing.T) {
+	now := time.Now()
+	secrets := []SigningSecret{
+		{Secret: config.Secret{Name: "expired", NotAfter: now.Add(-time.Second)}},
+		{Secret: config.Secret{Name: "expiring", NotAfter: now.Add(time.Hour)}},
+		{Secret: config.Secret{Name: "expires now", NotAfter: now}},
+		{Secret: config.Secret{Name: "never expires"}},
+	}
+	var names []string
+	for _, secret := range activeSecrets(secrets, now) {
+		names = append(names, secret.Name)
+	}
+	if want := []string{"expiring", "never expires"}; !slices.Equal(names, want) {
+		t.Errorf("activeSecrets() = %q, want %q", names, want)
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		oldExpiry  time.Time
+		signedWith string
+		secretName string
+	}{
+		{name: "old secret during the rollover", oldExpiry: now.Add(time.Hour), signedWith: "old-secret", secretName: "old"},
+		{name: "next secret during the rollover", oldExpiry: now.Add(time.Hour), signedWith: "next-secret", secretName: "next"},
+		{name: "old secret after it expired", oldExpiry: now.Add(-time.Hour), signedWith: "old-secret"},
+		{name: "next secret after the old one expired", oldExpiry: now.Add(-time.Hour), signedWith: "next-secret", secretName: "next"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := NewHMACSHA256Verifier(&config.WebhookService{
+				AuthenticationHeader: "X-Signature",
+				SignatureEncoding:    "hex",
+				AuthenticationSecrets: []config.Secret{
+					{Name: "old", Value: "old-secret", NotAfter: tt.oldExpiry},
+					{Name: "next", Value: "next-secret"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("NewHMACSHA256Verifier failed: %v", err)
+			}
+			request, body := newSignedRequest(hmacBody, map[string]string{
+				"X-Signature": hex.EncodeToString(sign(sha256.New, tt.signedWith, hmacBody)),
+			})
+			verification, err := verifier.Verify(context.Background(), request, body)
+			if tt.secretName == "" {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("Verify() error = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() failed: %v", err)
+			}
+			if verification.SecretName != tt.secretName {
+				t.Errorf("SecretName = %q, want %q", verification.SecretName, tt.secretName)
+			}
+		})
+	}
+}
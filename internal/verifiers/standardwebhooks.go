@@ -0,0 +1,93 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"laile/internal/config"
+)
+
+// StandardWebhooksVerifier checks requests signed with the Standard Webhooks scheme used by Svix, Resend, Clerk and others.
+// The signed payload is "<webhook-id>.<webhook-timestamp>.<body>" and the webhook-signature header carries
+// space separated "v1,<base64 hmac>" entries. Svix' older "svix-" prefixed headers are accepted as well.
+type StandardWebhooksVerifier struct {
+	Secrets   []SigningSecret
+	Tolerance time.Duration
+}
+
+const standardWebhooksSecretPrefix = "whsec_"
+
+func NewStandardWebhooksVerifier(service *config.WebhookService) (Verifier, error) {
+	secrets, err := signingSecrets(service, decodeStandardWebhooksSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &StandardWebhooksVerifier{
+		Secrets:   secrets,
+		Tolerance: time.Duration(service.SignatureTolerance) * time.Second,
+	}, nil
+}
+
+func decodeStandardWebhooksSecret(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, standardWebhooksSecretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode standard webhooks secret: %w", err)
+	}
+	return key, nil
+}
+
+func (v *StandardWebhooksVerifier) Verify(_ context.Context, request *http.Request, body io.ReadSeeker) (*Verification, error) {
+	messageID, timestamp, signatures := standardWebhooksHeaders(request.Header)
+	if messageID == "" || timestamp == "" || signatures == "" {
+		return nil, fmt.Errorf("%w: missing webhook-id, webhook-timestamp or webhook-signature header", ErrUnauthorized)
+	}
+
+	signedAt, err := checkTimestamp(timestamp, v.Tolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded [][]byte
+	for _, entry := range strings.Fields(signatures) {
+		version, signature, found := strings.Cut(entry, ",")
+		if !found || version != "v1" {
+			continue
+		}
+		signatureBytes, decodeErr := base64.StdEncoding.DecodeString(signature)
+		if decodeErr == nil {
+			decoded = append(decoded, signatureBytes)
+		}
+	}
+
+	for _, secret := range activeSecrets(v.Secrets, time.Now()) {
+		expected, err := computeHMAC(sha256.New, secret.Key, body, []byte(messageID), []byte("."), []byte(timestamp), []byte("."))
+		if err != nil {
+			return nil, err
+		}
+		for _, signature := range decoded {
+			if hmac.Equal(signature, expected) {
+				return &Verification{
+					ReplayKey:       base64.StdEncoding.EncodeToString(signature),
+					ReplayExpiresAt: signedAt.Add(v.Tolerance),
+					MessageID:       messageID,
+					SecretName:      secret.Name,
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no v1 signature in webhook-signature header matches the request body", ErrUnauthorized)
+}
+
+func standardWebhooksHeaders(header http.Header) (string, string, string) {
+	if header.Get("webhook-id") != "" {
+		return header.Get("webhook-id"), header.Get("webhook-timestamp"), header.Get("webhook-signature")
+	}
+	return header.Get("svix-id"), header.Get("svix-timestamp"), header.Get("svix-signature")
+}
@@ -0,0 +1,145 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"laile/internal/config"
+)
+
+// The test vector published with the Standard Webhooks specification
+const (
+	specSecret    = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	specMessageID = "msg_p5jXN8AQM9LWM0D4loKWxJek"
+	specTimestamp = 1614265330
+	specBody      = `{"test": 2432232314}`
+	specSignature = "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="
+)
+
+func newStandardWebhooksVerifier(t *testing.T, tolerance int) Verifier {
+	t.Helper()
+	verifier, err := NewStandardWebhooksVerifier(&config.WebhookService{
+		AuthenticationSecrets: []config.Secret{{Name: "current", Value: specSecret}},
+		SignatureTolerance:    tolerance,
+	})
+	if err != nil {
+		t.Fatalf("NewStandardWebhooksVerifier failed: %v", err)
+	}
+	return verifier
+}
+
+func standardWebhooksSignature(timestamp int64, messageID string, body string) string {
+	key, _ := decodeStandardWebhooksSecret(specSecret)
+	mac := sign(sha256.New, string(key), messageID, ".", strconv.FormatInt(timestamp, 10), ".", body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac)
+}
+
+func TestDecodeStandardWebhooksSecret(t *testing.T) {
+	withPrefix, err := decodeStandardWebhooksSecret(specSecret)
+	if err != nil {
+		t.Fatalf("decoding %q failed: %v", specSecret, err)
+	}
+	withoutPrefix, err := decodeStandardWebhooksSecret(specSecret[len(standardWebhooksSecretPrefix):])
+	if err != nil {
+		t.Fatalf("decoding the secret without its prefix failed: %v", err)
+	}
+	if string(withPrefix) != string(withoutPrefix) || len(withPrefix) != 24 {
+		t.Errorf("decoded secrets = %x and %x, want the same 24 byte key", withPrefix, withoutPrefix)
+	}
+
+	_, err = NewStandardWebhooksVerifier(&config.WebhookService{
+		AuthenticationSecrets: []config.Secret{{Name: "broken", Value: "whsec_not base64!"}},
+	})
+	if err == nil {
+		t.Error("NewStandardWebhooksVerifier accepted a secret that isn't base64")
+	}
+}
+
+func TestStandardWebhooksSpecVector(t *testing.T) {
+	// The vector was signed in 2021, so the tolerance has to reach back that far
+	tolerance := int(time.Since(time.Unix(specTimestamp, 0)).Seconds()) + 3600
+	verifier := newStandardWebhooksVerifier(t, tolerance)
+
+	for _, prefix := range []string{"webhook", "svix"} {
+		t.Run(prefix, func(t *testing.T) {
+			request, body := newSignedRequest(specBody, map[string]string{
+				prefix + "-id":        specMessageID,
+				prefix + "-timestamp": strconv.Itoa(specTimestamp),
+				prefix + "-signature": specSignature,
+			})
+			verification, err := verifier.Verify(context.Background(), request, body)
+			if err != nil {
+				t.Fatalf("Verify() failed: %v", err)
+			}
+			if verification.MessageID != specMessageID {
+				t.Errorf("MessageID = %q, want %q", verification.MessageID, specMessageID)
+			}
+			if verification.ReplayKey != specSignature[len("v1,"):] {
+				t.Errorf("ReplayKey = %q, want the matching signature", verification.ReplayKey)
+			}
+			if verification.SecretName != "current" {
+				t.Errorf("SecretName = %q, want %q", verification.SecretName, "current")
+			}
+		})
+	}
+
+	// The same request is too old for the default tolerance
+	request, body := newSignedRequest(specBody, map[string]string{
+		"webhook-id":        specMessageID,
+		"webhook-timestamp": strconv.Itoa(specTimestamp),
+		"webhook-signature": specSignature,
+	})
+	if _, err := newStandardWebhooksVerifier(t, 300).Verify(context.Background(), request, body); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Verify() of an expired signature error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestStandardWebhooksVerifier(t *testing.T) {
+	now := time.Now().Unix()
+	valid := standardWebhooksSignature(now, specMessageID, specBody)
+	nowText := strconv.FormatInt(now, 10)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		valid   bool
+	}{
+		{name: "valid", headers: map[string]string{"webhook-id": specMessageID, "webhook-timestamp": nowText, "webhook-signature": valid}, valid: true},
+		{name: "last of several signatures", headers: map[string]string{"webhook-id": specMessageID, "webhook-timestamp": nowText,
+			"webhook-signature": "v1,Zm9v v1,not-base64 " + valid}, valid: true},
+		{name: "other versions are skipped", headers: map[string]string{"webhook-id": specMessageID, "webhook-timestamp": nowText,
+			"webhook-signature": "v1a,Zm9v " + valid}, valid: true},
+		{name: "signature under another version", headers: map[string]string{"webhook-id": specMessageID, "webhook-timestamp": nowText,
+			"webhook-signature": "v2," + valid[len("v1,"):]}},
+		{name: "signed just inside the tolerance", headers: map[string]string{"webhook-id": specMessageID,
+			"webhook-timestamp": strconv.FormatInt(now-290, 10), "webhook-signature": standardWebhooksSignature(now-290, specMessageID, specBody)}, valid: true},
+		{name: "signed just outside the tolerance", headers: map[string]string{"webhook-id": specMessageID,
+			"webhook-timestamp": strconv.FormatInt(now-310, 10), "webhook-signature": standardWebhooksSignature(now-310, specMessageID, specBody)}},
+		{name: "signed too far in the future", headers: map[string]string{"webhook-id": specMessageID,
+			"webhook-timestamp": strconv.FormatInt(now+310, 10), "webhook-signature": standardWebhooksSignature(now+310, specMessageID, specBody)}},
+		{name: "other message ID", headers: map[string]string{"webhook-id": "msg_other", "webhook-timestamp": nowText, "webhook-signature": valid}},
+		{name: "missing signature", headers: map[string]string{"webhook-id": specMessageID, "webhook-timestamp": nowText}},
+		{name: "headers of both schemes mixed", headers: map[string]string{"webhook-id": specMessageID, "svix-timestamp": nowText, "svix-signature": valid}},
+	}
+	verifier := newStandardWebhooksVerifier(t, 300)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, body := newSignedRequest(specBody, tt.headers)
+			_, err := verifier.Verify(context.Background(), request, body)
+			if !tt.valid {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("Verify() error = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"laile/internal/config"
+)
+
+// StripeVerifier checks Stripe-Signature style headers: "t=<unix timestamp>,v1=<hex hmac>[,v1=...]".
+// The signed payload is "<timestamp>.<body>" and deliveries outside the tolerance window are rejected.
+type StripeVerifier struct {
+	Header    string
+	Secrets   []SigningSecret
+	Tolerance time.Duration
+}
+
+func NewStripeVerifier(service *config.WebhookService) (Verifier, error) {
+	secrets, err := signingSecrets(service, rawSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &StripeVerifier{
+		Header:    service.AuthenticationHeader,
+		Secrets:   secrets,
+		Tolerance: time.Duration(service.SignatureTolerance) * time.Second,
+	}, nil
+}
+
+func (v *StripeVerifier) Verify(_ context.Context, request *http.Request, body io.ReadSeeker) (*Verification, error) {
+	value := request.Header.Get(v.Header)
+	if value == "" {
+		return nil, fmt.Errorf("%w: missing %s header", ErrUnauthorized, v.Header)
+	}
+
+	timestamp, signatures, err := parseStripeSignature(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	signedAt, err := checkTimestamp(timestamp, v.Tolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := make([][]byte, 0, len(signatures))
+	for _, signature := range signatures {
+		signatureBytes, decodeErr := hex.DecodeString(signature)
+		if decodeErr == nil {
+			decoded = append(decoded, signatureBytes)
+		}
+	}
+
+	// Stripe sends one v1 signature per active secret while a secret is being rolled
+	for _, secret := range activeSecrets(v.Secrets, time.Now()) {
+		expected, err := computeHMAC(sha256.New, secret.Key, body, []byte(timestamp), []byte("."))
+		if err != nil {
+			return nil, err
+		}
+		for _, signature := range decoded {
+			if hmac.Equal(signature, expected) {
+				return &Verification{
+					ReplayKey:       hex.EncodeToString(signature),
+					ReplayExpiresAt: signedAt.Add(v.Tolerance),
+					MessageID:       "",
+					SecretName:      secret.Name,
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no v1 signature in %s header matches the request body", ErrUnauthorized, v.Header)
+}
+
+// parseStripeSignature returns the timestamp and every v1 signature from a Stripe-Signature header.
+func parseStripeSignature(value string) (string, []string, error) {
+	var timestamp string
+	var signatures []string
+	for _, item := range strings.Split(value, ",") {
+		key, itemValue, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = itemValue
+		case "v1":
+			signatures = append(signatures, itemValue)
+		}
+	}
+
+	if timestamp == "" {
+		return "", nil, errors.New("signature header has no timestamp")
+	}
+	if len(signatures) == 0 {
+		return "", nil, errors.New("signature header has no v1 signatures")
+	}
+	return timestamp, signatures, nil
+}
+
+// checkTimestamp parses a unix timestamp and rejects it if it is further than tolerance from now.
+func checkTimestamp(timestamp string, tolerance time.Duration) (time.Time, error) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid signature timestamp %q", ErrUnauthorized, timestamp)
+	}
+
+	signedAt := time.Unix(seconds, 0)
+	age := time.Since(signedAt)
+	if age > tolerance || age < -tolerance {
+		return time.Time{}, fmt.Errorf("%w: signature timestamp is outside the %s tolerance", ErrUnauthorized, tolerance)
+	}
+	return signedAt, nil
+}
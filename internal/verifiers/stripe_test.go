@@ -0,0 +1,145 @@
+package verifiers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"laile/internal/config"
+)
+
+const stripeBody = `{"id": "evt_123", "type": "invoice.paid"}`
+
+func stripeSignature(secret string, timestamp int64, body string) string {
+	return hex.EncodeToString(sign(sha256.New, secret, strconv.FormatInt(timestamp, 10), ".", body))
+}
+
+func newStripeVerifier(t *testing.T) Verifier {
+	t.Helper()
+	verifier, err := NewStripeVerifier(&config.WebhookService{
+		AuthenticationHeader:  "Stripe-Signature",
+		AuthenticationSecrets: []config.Secret{{Name: "current", Value: "whsec_test"}},
+		SignatureTolerance:    300,
+	})
+	if err != nil {
+		t.Fatalf("NewStripeVerifier failed: %v", err)
+	}
+	return verifier
+}
+
+func TestParseStripeSignature(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		timestamp  string
+		signatures []string
+		valid      bool
+	}{
+		{name: "single signature", header: "t=100,v1=abc", timestamp: "100", signatures: []string{"abc"}, valid: true},
+		{name: "several signatures", header: "t=100,v1=abc,v1=def", timestamp: "100", signatures: []string{"abc", "def"}, valid: true},
+		{name: "other schemes are ignored", header: "t=100,v0=old,v1=abc", timestamp: "100", signatures: []string{"abc"}, valid: true},
+		{name: "spaces around items", header: "t=100, v1=abc", timestamp: "100", signatures: []string{"abc"}, valid: true},
+		{name: "signature before timestamp", header: "v1=abc,t=100", timestamp: "100", signatures: []string{"abc"}, valid: true},
+		{name: "items without a value are skipped", header: "t=100,junk,v1=abc", timestamp: "100", signatures: []string{"abc"}, valid: true},
+		{name: "no timestamp", header: "v1=abc"},
+		{name: "no v1 signature", header: "t=100,v0=old"},
+		{name: "empty", header: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp, signatures, err := parseStripeSignature(tt.header)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("parseStripeSignature(%q) = %q, %q, want an error", tt.header, timestamp, signatures)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseStripeSignature(%q) failed: %v", tt.header, err)
+			}
+			if timestamp != tt.timestamp || !slices.Equal(signatures, tt.signatures) {
+				t.Errorf("parseStripeSignature(%q) = %q, %q, want %q, %q", tt.header, timestamp, signatures,
+					tt.timestamp, tt.signatures)
+			}
+		})
+	}
+}
+
+func TestStripeVerifier(t *testing.T) {
+	now := time.Now().Unix()
+	valid := stripeSignature("whsec_test", now, stripeBody)
+
+	tests := []struct {
+		name   string
+		header string
+		valid  bool
+	}{
+		{name: "valid", header: fmt.Sprintf("t=%d,v1=%s", now, valid), valid: true},
+		{name: "second v1 matches", header: fmt.Sprintf("t=%d,v1=%s,v1=%s", now, stripeSignature("old", now, stripeBody), valid), valid: true},
+		{name: "undecodable v1 next to a valid one", header: fmt.Sprintf("t=%d,v1=zz,v1=%s", now, valid), valid: true},
+		{name: "signed just inside the tolerance", header: fmt.Sprintf("t=%d,v1=%s", now-290, stripeSignature("whsec_test", now-290, stripeBody)), valid: true},
+		{name: "signed just outside the tolerance", header: fmt.Sprintf("t=%d,v1=%s", now-310, stripeSignature("whsec_test", now-310, stripeBody))},
+		{name: "signed in the future within the tolerance", header: fmt.Sprintf("t=%d,v1=%s", now+290, stripeSignature("whsec_test", now+290, stripeBody)), valid: true},
+		{name: "signed too far in the future", header: fmt.Sprintf("t=%d,v1=%s", now+310, stripeSignature("whsec_test", now+310, stripeBody))},
+		{name: "timestamp changed after signing", header: fmt.Sprintf("t=%d,v1=%s", now+1, valid)},
+		{name: "invalid timestamp", header: fmt.Sprintf("t=yesterday,v1=%s", valid)},
+		{name: "wrong secret", header: fmt.Sprintf("t=%d,v1=%s", now, stripeSignature("whsec_other", now, stripeBody))},
+		{name: "only a v0 signature", header: fmt.Sprintf("t=%d,v0=%s", now, valid)},
+		{name: "missing header"},
+	}
+	verifier := newStripeVerifier(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["Stripe-Signature"] = tt.header
+			}
+			request, body := newSignedRequest(stripeBody, headers)
+			verification, err := verifier.Verify(context.Background(), request, body)
+			if !tt.valid {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("Verify() error = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() failed: %v", err)
+			}
+			if verification.SecretName != "current" {
+				t.Errorf("SecretName = %q, want %q", verification.SecretName, "current")
+			}
+		})
+	}
+}
+
+func TestStripeVerifierReplayKey(t *testing.T) {
+	verifier := newStripeVerifier(t)
+	signedAt := time.Now().Add(-time.Minute).Unix()
+	signature := stripeSignature("whsec_test", signedAt, stripeBody)
+
+	// The replay cache only catches a replay if every copy of the request gets the same key
+	var keys []string
+	for _, header := range []string{
+		fmt.Sprintf("t=%d,v1=%s", signedAt, signature),
+		fmt.Sprintf("t=%d,v1=%s,v1=%s", signedAt, stripeSignature("old", signedAt, stripeBody), signature),
+	} {
+		request, body := newSignedRequest(stripeBody, map[string]string{"Stripe-Signature": header})
+		verification, err := verifier.Verify(context.Background(), request, body)
+		if err != nil {
+			t.Fatalf("Verify(%q) failed: %v", header, err)
+		}
+		if want := time.Unix(signedAt, 0).Add(300 * time.Second); !verification.ReplayExpiresAt.Equal(want) {
+			t.Errorf("ReplayExpiresAt = %v, want %v", verification.ReplayExpiresAt, want)
+		}
+		keys = append(keys, verification.ReplayKey)
+	}
+	if keys[0] != signature || keys[1] != signature {
+		t.Errorf("ReplayKey = %q, want the matching signature %q for every copy", keys, signature)
+	}
+}
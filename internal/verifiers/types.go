@@ -0,0 +1,38 @@
+// Package verifiers checks that inbound webhook requests were sent by the provider configured for a service.
+package verifiers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrUnauthorized is returned when a request does not carry a valid signature for its service.
+var ErrUnauthorized = errors.New("webhook request failed signature verification")
+
+type Verifier interface {
+	// Verify returns an error wrapping ErrUnauthorized if the request was not signed with the service secret.
+	// The body can be read more than once by seeking back to the start.
+	Verify(ctx context.Context, request *http.Request, body io.ReadSeeker) (*Verification, error)
+}
+
+// Verification describes a request that passed verification.
+type Verification struct {
+	// ReplayKey identifies the accepted signature. It is empty for schemes without replay protection.
+	ReplayKey string
+	// ReplayExpiresAt is when the signature falls outside the tolerance window and no longer needs to be remembered.
+	ReplayExpiresAt time.Time
+	// MessageID is the provider's unique ID for the message, if the scheme signs one. Retries reuse the same ID.
+	MessageID string
+	// SecretName is the name of the secret that produced the matching signature.
+	SecretName string
+}
+
+// allowAllVerifier is used by services that don't configure an authentication type.
+type allowAllVerifier struct{}
+
+func (allowAllVerifier) Verify(_ context.Context, _ *http.Request, _ io.ReadSeeker) (*Verification, error) {
+	return &Verification{}, nil
+}